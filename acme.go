@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newACMEManager returns a manager that obtains and renews certificates for domains
+// from Let's Encrypt, keeping them in cacheDir. The cache holds private keys, so it
+// may not be inside any of the served directories
+func newACMEManager(domains []string, cacheDir string, served []string) (*autocert.Manager, error) {
+	if len(domains) == 0 {
+		return nil, errors.New("-acme needs -domains to list the names to get certificates for")
+	}
+	cache, err := filepath.Abs(cacheDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range served {
+		if within(dir, cache) {
+			return nil, fmt.Errorf("-acme-cache %s is inside the served directory %s, which would expose its keys", cache, dir)
+		}
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(cache),
+	}, nil
+}
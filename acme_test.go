@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"golang.org/x/crypto/acme"
+)
+
+func TestNewACMEManager(t *testing.T) {
+	served := t.TempDir()
+	if _, err := newACMEManager(nil, t.TempDir(), []string{served}); err == nil {
+		t.Error("no domains: expected an error")
+	}
+	if _, err := newACMEManager([]string{"example.com"}, filepath.Join(served, "acme"), []string{served}); err == nil {
+		t.Error("cache inside the served directory: expected an error")
+	}
+	m, err := newACMEManager([]string{"example.com"}, t.TempDir(), []string{served})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.HostPolicy(t.Context(), "example.com"); err != nil {
+		t.Errorf("listed domain refused: %v", err)
+	}
+	if err := m.HostPolicy(t.Context(), "other.example"); err == nil {
+		t.Error("unlisted domain allowed")
+	}
+
+	// challenges are answered by the manager, everything else passes through
+	handler := m.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("site"))
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/page", nil))
+	if rec.Body.String() != "site" {
+		t.Errorf("got %d %q for a page, want it served", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/.well-known/acme-challenge/token", nil))
+	if rec.Body.String() == "site" {
+		t.Error("challenge passed through to the site")
+	}
+
+	srv := &Server{tlsServer: &http.Server{TLSConfig: &tls.Config{}}, acme: m}
+	if err := srv.loadCertificate(); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(srv.tlsServer.TLSConfig.NextProtos, acme.ALPNProto) {
+		t.Errorf("got NextProtos %q, want %s for TLS-ALPN-01", srv.tlsServer.TLSConfig.NextProtos, acme.ALPNProto)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// loadHtpasswd reads user:hash lines from an htpasswd file, supporting bcrypt
+// ($2y$ and the other $2 variants), {SHA}, $apr1$ (Apache MD5) and plaintext entries
+func loadHtpasswd(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	users := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(text, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("%s:%d: expected user:hash", path, line)
+		}
+		if strings.HasPrefix(hash, "$2") {
+			if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid bcrypt hash: %w", path, line, err)
+			}
+		}
+		users[user] = hash
+	}
+	return users, scanner.Err()
+}
+
+// checkPassword compares password against a stored htpasswd hash in constant time
+func checkPassword(stored, password string) bool {
+	var computed string
+	switch {
+	case strings.HasPrefix(stored, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	case strings.HasPrefix(stored, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		computed = "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	case strings.HasPrefix(stored, "$apr1$"):
+		salt, _, _ := strings.Cut(stored[len("$apr1$"):], "$")
+		computed = apr1(password, salt)
+	default:
+		computed = password
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(computed)) == 1
+}
+
+// apr1 implements Apache's MD5-based crypt variant
+func apr1(password, salt string) string {
+	const magic = "$apr1$"
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alt := md5.Sum([]byte(password + salt + password))
+	ctx := md5.New()
+	ctx.Write([]byte(password + magic + salt))
+	for i := len(pw); i > 0; i -= 16 {
+		ctx.Write(alt[:min(i, 16)])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	final := ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		c := md5.New()
+		if i&1 != 0 {
+			c.Write(pw)
+		} else {
+			c.Write(final)
+		}
+		if i%3 != 0 {
+			c.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			c.Write(pw)
+		}
+		if i&1 != 0 {
+			c.Write(final)
+		} else {
+			c.Write(pw)
+		}
+		final = c.Sum(nil)
+	}
+
+	var out strings.Builder
+	to64 := func(v uint, n int) {
+		for ; n > 0; n-- {
+			out.WriteByte(itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		to64(uint(final[g[0]])<<16|uint(final[g[1]])<<8|uint(final[g[2]]), 4)
+	}
+	to64(uint(final[11]), 2)
+	return magic + salt + "$" + out.String()
+}
+
+// loadUsers builds the user table from -auth-user/-auth-pass and -auth-file
+func loadUsers(user, pass, file string) (map[string]string, error) {
+	users := map[string]string{}
+	if file != "" {
+		var err error
+		if users, err = loadHtpasswd(file); err != nil {
+			return nil, err
+		}
+	}
+	if user != "" {
+		if pass == "" {
+			return nil, errors.New("-auth-user requires -auth-pass")
+		}
+		users[user] = pass
+	}
+	return users, nil
+}
+
+// basicAuthHandler requires HTTP Basic credentials matching one of users
+func basicAuthHandler(h http.Handler, users map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if ok {
+			stored, found := users[user]
+			if checkPassword(stored, pass) && found {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="gomoose", charset="UTF-8"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckPassword(t *testing.T) {
+	// hashes made with openssl passwd -apr1, glibc's crypt (bcrypt) and SHA-1
+	for _, tc := range []struct {
+		stored, password string
+		want             bool
+	}{
+		{"$apr1$5Z5fU3/M$oP9uV/RhIhQkwoAuRcbng.", "hunter2", true},
+		{"$apr1$5Z5fU3/M$oP9uV/RhIhQkwoAuRcbng.", "hunter3", false},
+		{"$apr1$abc$Q90.kW6FW3iF.V18kJ7M71", "p@ss word", true},
+		{"$apr1$abc$Q90.kW6FW3iF.V18kJ7M71", "", false},
+		{"{SHA}87u9ZqY9S/F0eUBXjsPQEDUw4h0=", "hunter2", true},
+		{"{SHA}87u9ZqY9S/F0eUBXjsPQEDUw4h0=", "Hunter2", false},
+		{"$2b$05$abcdefghijklmnopqrstuuoXuKqgZXLiJqzfmMXDDhSFPIvxV7t8.", "hunter2", true},
+		{"$2b$05$abcdefghijklmnopqrstuuoXuKqgZXLiJqzfmMXDDhSFPIvxV7t8.", "hunter", false},
+		{"$2y$04$abcdefghijklmnopqrstuuaFOMICs5mh2DN7mlhbTRCU3QzhscKTi", "allmine", true},
+		{"$2a$10$XajjQvNhvvRt5GSeFk1xFeyqRrsxkhBkUiQeg0dt.wU1qD4aFDcga", "allmine", true},
+		{"$2a$10$XajjQvNhvvRt5GSeFk1xFeyqRrsxkhBkUiQeg0dt.wU1qD4aFDcga", "allmin", false},
+		{"plain", "plain", true},
+		{"plain", "plaintext", false},
+		{"", "", true},
+	} {
+		if got := checkPassword(tc.stored, tc.password); got != tc.want {
+			t.Errorf("checkPassword(%q, %q) = %t, want %t", tc.stored, tc.password, got, tc.want)
+		}
+	}
+}
+
+func TestLoadHtpasswd(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		name := filepath.Join(dir, "htpasswd")
+		if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+
+	users, err := loadHtpasswd(write("# comment\n\nalice:$2y$04$abcdefghijklmnopqrstuuaFOMICs5mh2DN7mlhbTRCU3QzhscKTi\nbob:{SHA}87u9ZqY9S/F0eUBXjsPQEDUw4h0=\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || !checkPassword(users["alice"], "allmine") || !checkPassword(users["bob"], "hunter2") {
+		t.Errorf("got users %v", users)
+	}
+	for _, bad := range []string{"nohash\n", ":hash\n", "carol:$2y$04$tooshort\n"} {
+		if _, err := loadHtpasswd(write(bad)); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestBasicAuthHandler(t *testing.T) {
+	handler := basicAuthHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}), map[string]string{"alice": "$2y$04$abcdefghijklmnopqrstuuaFOMICs5mh2DN7mlhbTRCU3QzhscKTi", "bob": "secret"})
+
+	for _, tc := range []struct {
+		user, pass string
+		set        bool
+		want       int
+	}{
+		{"alice", "allmine", true, http.StatusOK},
+		{"bob", "secret", true, http.StatusOK},
+		{"alice", "secret", true, http.StatusUnauthorized},
+		{"carol", "", true, http.StatusUnauthorized},
+		{"", "", false, http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.set {
+			req.SetBasicAuth(tc.user, tc.pass)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%q:%q: got %d, want %d", tc.user, tc.pass, rec.Code, tc.want)
+		}
+		if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%q:%q: 401 without a WWW-Authenticate challenge", tc.user, tc.pass)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"html"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	headTag = regexp.MustCompile(`(?i)<head(\s[^>]*)?>`)
+	baseTag = regexp.MustCompile(`(?i)<base[\s/>]`)
+)
+
+// insertBaseTag adds tag at the start of page's <head>, unless the page already has
+// a <base> tag or no <head> to put it in
+func insertBaseTag(page []byte, tag string) []byte {
+	loc := headTag.FindIndex(page)
+	if loc == nil || baseTag.Match(page) {
+		return page
+	}
+	out := make([]byte, 0, len(page)+len(tag))
+	out = append(out, page[:loc[1]]...)
+	out = append(out, tag...)
+	return append(out, page[loc[1]:]...)
+}
+
+// basePathWriter buffers HTML responses to add a <base> tag to them
+type basePathWriter struct {
+	http.ResponseWriter
+	tag         string
+	head        bool
+	buf         *bytes.Buffer
+	wroteHeader bool
+	status      int
+}
+
+func (w *basePathWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.Header()
+	if code == http.StatusOK && h.Get("Content-Encoding") == "" &&
+		strings.HasPrefix(h.Get("Content-Type"), "text/html") {
+		h.Del("Accept-Ranges")
+		h.Del("ETag")
+		h.Del("Content-Length")
+		if w.head {
+			w.ResponseWriter.WriteHeader(code)
+			return
+		}
+		w.buf = &bytes.Buffer{}
+		w.status = code
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *basePathWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.buf != nil {
+		return w.buf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *basePathWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish writes the buffered page with the tag added
+func (w *basePathWriter) finish() {
+	if w.buf == nil {
+		return
+	}
+	page := insertBaseTag(w.buf.Bytes(), w.tag)
+	w.Header().Set("Content-Length", strconv.Itoa(len(page)))
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(page)
+}
+
+// basePathHandler adds <base href="prefix/"> to HTML pages from h, so their relative
+// links resolve under prefix when they're served there by a proxy (or -strip-prefix),
+// even from URLs that don't end in a slash. Pages with their own <base> are left alone.
+// HEAD responses go without a Content-Length, as the page is needed to know it
+func basePathHandler(h http.Handler, prefix string) http.Handler {
+	tag := `<base href="` + html.EscapeString(prefix+"/") + `">`
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// ranges of pages would refer to the page without the tag
+		if mightBeHTML(r.URL.Path) {
+			r.Header.Del("Range")
+		}
+		bw := &basePathWriter{ResponseWriter: w, tag: tag, head: r.Method == http.MethodHead}
+		h.ServeHTTP(bw, r)
+		bw.finish()
+	})
+}
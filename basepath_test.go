@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestBasePathHandler(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"index.html":  `<html><HEAD lang="en"><title>app</title></head><body><a href="page">page</a></body></html>`,
+		"based.html":  `<html><head><base href="/other/"></head></html>`,
+		"nohead.html": `<p>fragment</p>`,
+		"app.js":      `document.write("<head>")`,
+	})
+	handler := basePathHandler(http.FileServer(http.Dir(dir)), "/app")
+	const tag = `<base href="/app/">`
+
+	for _, tc := range []struct {
+		path, body string
+	}{
+		{"/", `<html><HEAD lang="en">` + tag + `<title>app</title></head><body><a href="page">page</a></body></html>`},
+		{"/based.html", `<html><head><base href="/other/"></head></html>`},
+		{"/nohead.html", `<p>fragment</p>`},
+		{"/app.js", `document.write("<head>")`},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != tc.body {
+			t.Errorf("GET %s: got %d %q, want 200 %q", tc.path, rec.Code, rec.Body.String(), tc.body)
+		}
+		if got := rec.Header().Get("Content-Length"); got != strconv.Itoa(len(tc.body)) {
+			t.Errorf("GET %s: got Content-Length %q, want %d", tc.path, got, len(tc.body))
+		}
+	}
+
+	// a range of the page would be of the page without the tag
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Range", "bytes=0-5")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != "" {
+		t.Errorf("GET / with a Range: got %d with ETag %q, want the whole page without an ETag", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/", nil))
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 || rec.Header().Get("Content-Length") != "" {
+		t.Errorf("HEAD /: got %d with %d bytes and Content-Length %q, want 200 with neither", rec.Code, rec.Body.Len(), rec.Header().Get("Content-Length"))
+	}
+
+	// pages already compressed by gzipHandler can't be edited
+	gzipped := basePathHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write([]byte("<head>"))
+	}), "/app")
+	rec = httptest.NewRecorder()
+	gzipped.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Body.String() != "<head>" {
+		t.Errorf("GET / compressed: got %q, want it unchanged", rec.Body.String())
+	}
+}
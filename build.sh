@@ -1,6 +1,7 @@
-GOOS=windows GOARCH=386 go build -o bin/gomoose-x86.exe
-GOOS=windows GOARCH=amd64 go build -o bin/gomoose.exe
-GOOS=linux GOARCH=386 go build -o bin/gomoose-x86
-GOOS=linux GOARCH=amd64 go build -o bin/gomoose
-GOOS=darwin GOARCH=386 go build -o bin/gomoose-darwin-x86
-GOOS=darwin GOARCH=amd64 go build -o bin/gomoose-darwin
+LDFLAGS="-X main.commit=$(git rev-parse --short HEAD) -X main.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+GOOS=windows GOARCH=386 go build -ldflags "$LDFLAGS" -o bin/gomoose-x86.exe
+GOOS=windows GOARCH=amd64 go build -ldflags "$LDFLAGS" -o bin/gomoose.exe
+GOOS=linux GOARCH=386 go build -ldflags "$LDFLAGS" -o bin/gomoose-x86
+GOOS=linux GOARCH=amd64 go build -ldflags "$LDFLAGS" -o bin/gomoose
+GOOS=darwin GOARCH=386 go build -ldflags "$LDFLAGS" -o bin/gomoose-darwin-x86
+GOOS=darwin GOARCH=amd64 go build -ldflags "$LDFLAGS" -o bin/gomoose-darwin
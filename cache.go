@@ -0,0 +1,99 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// assetTypes are the content type prefixes that -cache-assets applies to
+var assetTypes = []string{"text/css", "text/javascript", "application/javascript", "image/", "font/", "application/wasm"}
+
+// cachePolicy picks the Cache-Control value for a response by its type: html for
+// HTML pages and assets for scripts, styles, images and fonts, marking assets
+// immutable when their path matches immutable (like a fingerprinted app.3f9a2c1b.js).
+// Other responses, and types without a duration, get fallback
+type cachePolicy struct {
+	fallback  string
+	html      time.Duration
+	assets    time.Duration
+	immutable *regexp.Regexp
+}
+
+func maxAge(d time.Duration) string {
+	return "max-age=" + strconv.Itoa(int(d.Seconds()))
+}
+
+func (p *cachePolicy) cacheControl(contentType, urlPath string) string {
+	if contentType == "" {
+		// 304 responses don't carry a Content-Type, but should keep the same caching
+		contentType = mime.TypeByExtension(path.Ext(urlPath))
+	}
+	if p.html > 0 && strings.HasPrefix(contentType, "text/html") {
+		return maxAge(p.html)
+	}
+	if p.assets > 0 {
+		for _, prefix := range assetTypes {
+			if strings.HasPrefix(contentType, prefix) {
+				if p.immutable != nil && p.immutable.MatchString(urlPath) {
+					return maxAge(p.assets) + ", immutable"
+				}
+				return maxAge(p.assets)
+			}
+		}
+	}
+	return p.fallback
+}
+
+// cacheWriter adjusts caching headers once the response status is known
+type cacheWriter struct {
+	http.ResponseWriter
+	policy      *cachePolicy
+	path        string
+	noETag      bool
+	wroteHeader bool
+}
+
+func (w *cacheWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		h := w.Header()
+		if cc := w.policy.cacheControl(h.Get("Content-Type"), w.path); cc != "" && code < http.StatusBadRequest {
+			h.Set("Cache-Control", cc)
+		}
+		if w.noETag {
+			h.Del("ETag")
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cacheWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// cacheHandler sets Cache-Control on successful responses following policy, and
+// optionally strips ETags
+func cacheHandler(h http.Handler, policy *cachePolicy, noETag bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if noETag {
+			// without an ETag to compare against, If-None-Match would mask If-Modified-Since
+			r.Header.Del("If-None-Match")
+		}
+		h.ServeHTTP(&cacheWriter{ResponseWriter: w, policy: policy, path: r.URL.Path, noETag: noETag}, r)
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// certReloadInterval is how often -cert-reload checks the cert and key for changes
+const certReloadInterval = 10 * time.Second
+
+// certReloader serves a certificate pair, reloading it when the files change and
+// stapling OCSP responses to it
+type certReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+	certMod  time.Time
+	keyMod   time.Time
+	reloaded chan struct{}
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, reloaded: make(chan struct{}, 1)}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// load reads and validates the pair, only replacing the served certificate on success
+func (r *certReloader) load() error {
+	certMod, keyMod := modTime(r.certFile), modTime(r.keyFile)
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert.Store(&cert)
+	r.certMod, r.keyMod = certMod, keyMod
+	return nil
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// watch polls the files until ctx is done, reloading when either is modified
+func (r *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if modTime(r.certFile).Equal(r.certMod) && modTime(r.keyFile).Equal(r.keyMod) {
+			continue
+		}
+		if err := r.load(); err != nil {
+			slog.Error("Unable to reload SSL certificate, keeping the current one", "cert", r.certFile, "err", err)
+			// retry only once the files change again
+			r.certMod, r.keyMod = modTime(r.certFile), modTime(r.keyFile)
+			continue
+		}
+		slog.Info("Reloaded SSL certificate", "cert", r.certFile)
+		select {
+		case r.reloaded <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// modTime returns the modification time of file, or the zero time if it can't be read
+func modTime(file string) time.Time {
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
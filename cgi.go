@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/cgi"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// cgiHandler runs scripts ending in ext from the directory under root served at
+// prefix, passing any path after the script as PATH_INFO. Everything else under
+// prefix gets a 404, so the scripts can never be downloaded as plain files. Scripts
+// are found through safeFS, so unless followSymlinks is set, links out of root can't
+// run anything outside it
+func cgiHandler(h http.Handler, root, prefix, ext string, followSymlinks bool) http.Handler {
+	prefix = "/" + strings.Trim(prefix, "/")
+	fsys := newSafeFS(root, followSymlinks)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := path.Clean(r.URL.Path)
+		if p != prefix && !strings.HasPrefix(p, prefix+"/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		script := prefix
+		for _, part := range strings.Split(strings.TrimPrefix(p, prefix), "/")[1:] {
+			script += "/" + part
+			if !strings.HasSuffix(part, ext) {
+				continue
+			}
+			info, err := fs.Stat(fsys, strings.TrimPrefix(script, "/"))
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			file := filepath.Join(root, filepath.FromSlash(script))
+			// the cgi package takes PATH_INFO from the path after Root, so it must be clean
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.URL = new(url.URL)
+			*r2.URL = *r.URL
+			r2.URL.Path = p
+			handler := &cgi.Handler{
+				Path: file,
+				Root: script,
+				Dir:  filepath.Dir(file),
+				Env:  []string{"SERVER_SOFTWARE=gomoose/" + version, "DOCUMENT_ROOT=" + root},
+			}
+			handler.ServeHTTP(w, r2)
+			return
+		}
+		http.NotFound(w, r)
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCGIHandler(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	scripts := map[string]string{
+		"cgi-bin/env.cgi": "#!/bin/sh\nprintf 'Content-Type: text/plain\\n\\n'\n" +
+			"echo \"$REQUEST_METHOD $SCRIPT_NAME|$PATH_INFO|$QUERY_STRING|$SERVER_SOFTWARE|$DOCUMENT_ROOT|$(pwd)\"\n",
+		"cgi-bin/status.cgi":    "#!/bin/sh\nprintf 'Status: 418 Teapot\\nContent-Type: text/plain\\n\\nshort'\n",
+		"cgi-bin/noheaders.cgi": "#!/bin/sh\nexit 1\n",
+	}
+	for name, script := range scripts {
+		full := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, perm := range map[string]os.FileMode{
+		filepath.Join(root, "cgi-bin", "readme.txt"):   0644,
+		filepath.Join(root, "cgi-bin", "plain.cgi"):    0644,
+		filepath.Join(outside, "escape.cgi"):           0755,
+		filepath.Join(root, "cgi-bin", "dir.cgi", "x"): 0644,
+	} {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(scripts["cgi-bin/env.cgi"]), perm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(outside, "escape.cgi"), filepath.Join(root, "cgi-bin", "link.cgi")); err != nil {
+		t.Fatal(err)
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("next"))
+	})
+	handler := cgiHandler(next, root, "cgi-bin", ".cgi", false)
+
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	rec := get("/cgi-bin/env.cgi/extra/./path?q=1")
+	want := "GET /cgi-bin/env.cgi|/extra/path|q=1|gomoose/" + version + "|" + root + "|" + filepath.Join(root, "cgi-bin") + "\n"
+	if rec.Code != http.StatusOK || rec.Body.String() != want {
+		t.Errorf("GET /cgi-bin/env.cgi/extra/./path?q=1: got %d %q, want 200 %q", rec.Code, rec.Body.String(), want)
+	}
+	if rec := get("/cgi-bin/status.cgi"); rec.Code != 418 || rec.Body.String() != "short" {
+		t.Errorf("GET /cgi-bin/status.cgi: got %d %q, want 418 %q", rec.Code, rec.Body.String(), "short")
+	}
+
+	for _, tc := range []struct {
+		path string
+		code int
+	}{
+		{"/cgi-bin/noheaders.cgi", http.StatusInternalServerError},
+		{"/cgi-bin/plain.cgi", http.StatusInternalServerError},
+		{"/cgi-bin/readme.txt", http.StatusNotFound},
+		{"/cgi-bin/missing.cgi", http.StatusNotFound},
+		{"/cgi-bin/dir.cgi/x", http.StatusNotFound},
+		{"/cgi-bin/link.cgi", http.StatusNotFound},
+		{"/cgi-bin/", http.StatusNotFound},
+	} {
+		if rec := get(tc.path); rec.Code != tc.code {
+			t.Errorf("GET %s: got %d, want %d", tc.path, rec.Code, tc.code)
+		}
+	}
+
+	for _, p := range []string{"/page.txt", "/cgi-bin-other/env.cgi", "/cgi-bin/../page.txt"} {
+		if rec := get(p); rec.Body.String() != "next" {
+			t.Errorf("GET %s: got %d %q, want it passed on", p, rec.Code, rec.Body.String())
+		}
+	}
+
+	// with -follow-symlinks, links are run like any other script
+	handler = cgiHandler(next, root, "cgi-bin", ".cgi", true)
+	if rec := get("/cgi-bin/link.cgi"); rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), "GET /cgi-bin/link.cgi|") {
+		t.Errorf("GET /cgi-bin/link.cgi with symlinks followed: got %d %q", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// clientIP returns the address of the client making r. When the connecting peer is
+// a trusted proxy, that's the rightmost X-Forwarded-For entry that isn't one too, so
+// clients can't pick their address by sending the header themselves. Otherwise, and
+// always when no proxies are trusted, it's the peer's address
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !trustProxy && !isTrustedProxy(ip) {
+		return ip
+	}
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// isTrustedProxy reports whether ip is in the -trusted-proxies ranges
+func isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && trustedProxies.contains(parsed)
+}
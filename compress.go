@@ -0,0 +1,192 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// responses smaller than this aren't worth compressing
+const gzipMinSize = 1024
+
+// extensions of formats that are already compressed
+var gzipSkipExts = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".avif": true, ".ico": true,
+	".mp4": true, ".webm": true, ".mkv": true, ".mov": true, ".avi": true,
+	".mp3": true, ".ogg": true, ".flac": true, ".m4a": true,
+	".zip": true, ".gz": true, ".bz2": true, ".xz": true, ".7z": true, ".rar": true,
+	".woff": true, ".woff2": true, ".pdf": true,
+}
+
+// parseAcceptEncoding maps each coding in an Accept-Encoding header to its quality value
+func parseAcceptEncoding(header string) map[string]float64 {
+	accepted := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				v, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil || v < 0 || v > 1 {
+					v = 0
+				}
+				q = v
+			}
+		}
+		accepted[name] = q
+	}
+	return accepted
+}
+
+// negotiateEncoding picks the offered encoding the client rates highest, preferring
+// earlier offers on ties, or returns "" when only identity is acceptable
+func negotiateEncoding(r *http.Request, offered ...string) string {
+	accepted := parseAcceptEncoding(r.Header.Get("Accept-Encoding"))
+	best, bestQ := "", 0.0
+	for _, enc := range offered {
+		q, ok := accepted[enc]
+		if !ok {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// addVary adds name to the Vary header unless it's already listed. Handlers that
+// negotiate an encoding call it before deciding, as the uncompressed responses they
+// fall back to vary too, and caches mustn't serve them to clients that accept gzip
+func addVary(h http.Header, name string) {
+	for _, value := range h.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
+
+// newCompressor returns a writer compressing to w with encoding at -compress-level.
+// HTTP's deflate is the zlib format (RFC 1950), not a raw deflate stream
+func newCompressor(w io.Writer, encoding string) io.WriteCloser {
+	switch encoding {
+	case "br":
+		level := compressLevel
+		if level == gzip.DefaultCompression {
+			level = brotli.DefaultCompression
+		} else if level < brotli.BestSpeed {
+			level = brotli.BestSpeed
+		}
+		return brotli.NewWriterLevel(w, level)
+	case "deflate":
+		zw, _ := zlib.NewWriterLevel(w, compressLevel)
+		return zw
+	}
+	gw, _ := gzip.NewWriterLevel(w, compressLevel)
+	return gw
+}
+
+// gzipResponseWriter compresses 200 responses with encoding: gzip, deflate or br
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	encoding    string
+	gz          io.WriteCloser
+	head        bool
+	discard     bool
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.Header()
+	if code == http.StatusOK && h.Get("Content-Encoding") == "" {
+		size, err := strconv.Atoi(h.Get("Content-Length"))
+		if err != nil || size >= gzipMinSize {
+			h.Set("Content-Encoding", w.encoding)
+			h.Del("Content-Length")
+			h.Del("Accept-Ranges")
+			// the compressed bytes differ from those a strong ETag was computed for
+			if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				h.Set("ETag", "W/"+etag)
+			}
+			// a HEAD response gets the same headers as a GET, but there's nothing to
+			// compress, and the compressed length isn't known without the body
+			if w.head {
+				w.discard = true
+			} else {
+				w.gz = newCompressor(w.ResponseWriter, w.encoding)
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	if w.discard {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *gzipResponseWriter) Close() error {
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
+
+// gzipHandler compresses responses for clients that accept gzip or deflate, preferring
+// gzip, except to Range requests. With brotli, Brotli is offered too, ahead of both
+func gzipHandler(h http.Handler, brotli bool) http.Handler {
+	offered := []string{"gzip", "deflate"}
+	if brotli {
+		offered = append([]string{"br"}, offered...)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if gzipSkipExts[strings.ToLower(path.Ext(r.URL.Path))] {
+			h.ServeHTTP(w, r)
+			return
+		}
+		addVary(w.Header(), "Accept-Encoding")
+		// byte ranges refer to the uncompressed file, so ranged requests are never
+		// compressed; gzipResponseWriter also only compresses 200 responses, never a 206
+		enc := negotiateEncoding(r, offered...)
+		if r.Header.Get("Range") != "" || enc == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w, encoding: enc, head: r.Method == http.MethodHead}
+		defer gw.Close()
+		h.ServeHTTP(gw, r)
+	})
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+// writeTestFiles creates files (name to content) in a new temporary directory
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		full := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestGzipSkipsRanges(t *testing.T) {
+	body := strings.Repeat("0123456789", 500)
+	dir := writeTestFiles(t, map[string]string{"data.txt": body})
+	handler := gzipHandler(http.FileServer(http.Dir(dir)), false)
+
+	req := httptest.NewRequest(http.MethodGet, "/data.txt", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Range", "bytes=10-19")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusPartialContent)
+	}
+	if enc := rec.Header().Get("Content-Encoding"); enc != "" {
+		t.Errorf("ranged response has Content-Encoding %q", enc)
+	}
+	if cr := rec.Header().Get("Content-Range"); cr != "bytes 10-19/5000" {
+		t.Errorf("got Content-Range %q, want %q", cr, "bytes 10-19/5000")
+	}
+	if got := rec.Body.String(); got != body[10:20] {
+		t.Errorf("got body %q, want %q", got, body[10:20])
+	}
+}
+
+func TestVaryAcceptEncoding(t *testing.T) {
+	body := strings.Repeat("0123456789", 500)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(body))
+	zw.Close()
+	dir := writeTestFiles(t, map[string]string{"data.txt": body, "data.txt.gz": gz.String()})
+	fsys := os.DirFS(dir)
+	files := http.FileServer(http.FS(fsys))
+
+	for _, tc := range []struct {
+		name    string
+		handler http.Handler
+	}{
+		{"gzip", gzipHandler(files, false)},
+		{"precompressed", precompressedHandler(files, fsys)},
+		{"both", gzipHandler(precompressedHandler(files, fsys), false)},
+	} {
+		// shared caches key on Vary, so it has to be on both variants
+		for _, accept := range []string{"gzip", ""} {
+			req := httptest.NewRequest(http.MethodGet, "/data.txt", nil)
+			if accept != "" {
+				req.Header.Set("Accept-Encoding", accept)
+			}
+			rec := httptest.NewRecorder()
+			tc.handler.ServeHTTP(rec, req)
+
+			if vary := rec.Header().Values("Vary"); len(vary) != 1 || vary[0] != "Accept-Encoding" {
+				t.Errorf("%s, Accept-Encoding %q: got Vary %q", tc.name, accept, vary)
+			}
+			if enc := rec.Header().Get("Content-Encoding"); enc != accept {
+				t.Errorf("%s, Accept-Encoding %q: got Content-Encoding %q", tc.name, accept, enc)
+				continue
+			}
+			var got []byte
+			if accept == "gzip" {
+				zr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Errorf("%s: %v", tc.name, err)
+					continue
+				}
+				got, _ = io.ReadAll(zr)
+			} else {
+				got = rec.Body.Bytes()
+			}
+			if string(got) != body {
+				t.Errorf("%s, Accept-Encoding %q: got %d bytes of body, want %d", tc.name, accept, len(got), len(body))
+			}
+		}
+	}
+}
+
+func TestGzipHandler(t *testing.T) {
+	body := strings.Repeat("0123456789", 500)
+	dir := writeTestFiles(t, map[string]string{"data.txt": body, "small.txt": "small", "photo.jpg": body})
+	handler := gzipHandler(http.FileServer(http.Dir(dir)), false)
+
+	for _, tc := range []struct {
+		path, accept, want string
+	}{
+		{"/data.txt", "gzip", "gzip"},
+		{"/data.txt", "deflate", "deflate"},
+		{"/data.txt", "deflate, gzip", "gzip"},
+		{"/data.txt", "gzip;q=0.5, deflate", "deflate"},
+		{"/data.txt", "gzip;q=0", ""},
+		{"/data.txt", "*", "gzip"},
+		{"/data.txt", "", ""},
+		{"/small.txt", "gzip", ""},
+		{"/photo.jpg", "gzip", ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		req.Header.Set("Accept-Encoding", tc.accept)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if enc := rec.Header().Get("Content-Encoding"); enc != tc.want {
+			t.Errorf("%s with %q: got Content-Encoding %q, want %q", tc.path, tc.accept, enc, tc.want)
+			continue
+		}
+		var r io.Reader = rec.Body
+		switch tc.want {
+		case "gzip":
+			zr, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = zr
+		case "deflate":
+			zr, err := zlib.NewReader(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = zr
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Errorf("%s with %q: %v", tc.path, tc.accept, err)
+			continue
+		}
+		want, _ := os.ReadFile(dir + tc.path)
+		if string(got) != string(want) {
+			t.Errorf("%s with %q: got %d bytes, want %d", tc.path, tc.accept, len(got), len(want))
+		}
+		if tc.want != "" && rec.Header().Get("Content-Length") != "" {
+			t.Errorf("%s with %q: compressed response kept Content-Length", tc.path, tc.accept)
+		}
+	}
+}
+
+func TestBrotli(t *testing.T) {
+	body := strings.Repeat("0123456789", 500)
+	dir := writeTestFiles(t, map[string]string{"data.txt": body})
+	files := http.FileServer(http.Dir(dir))
+
+	for _, tc := range []struct {
+		brotli       bool
+		accept, want string
+	}{
+		{true, "gzip, deflate, br", "br"},
+		{true, "br;q=0.9, gzip;q=0.8", "br"},
+		{true, "br;q=0.8, gzip;q=0.9", "gzip"},
+		{true, "br;q=0, gzip", "gzip"},
+		{true, "br;q=0, *;q=0.1", "gzip"},
+		{true, "identity", ""},
+		{false, "br", ""},
+		{false, "br, gzip", "gzip"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/data.txt", nil)
+		req.Header.Set("Accept-Encoding", tc.accept)
+		rec := httptest.NewRecorder()
+		gzipHandler(files, tc.brotli).ServeHTTP(rec, req)
+
+		if enc := rec.Header().Get("Content-Encoding"); enc != tc.want {
+			t.Errorf("brotli %t with %q: got Content-Encoding %q, want %q", tc.brotli, tc.accept, enc, tc.want)
+			continue
+		}
+		if tc.want != "br" {
+			continue
+		}
+		got, err := io.ReadAll(brotli.NewReader(rec.Body))
+		if err != nil || string(got) != body {
+			t.Errorf("brotli %t with %q: got %d bytes, %v", tc.brotli, tc.accept, len(got), err)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// loadConfigFile applies settings from a JSON or YAML file, keyed by flag name,
+// to every flag that wasn't given on the command line
+func loadConfigFile(path string) error {
+	values, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	if err := applyFlagValues(values); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// readConfigFile parses a JSON or YAML config file into values for each flag
+func readConfigFile(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string][]string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		values, err = parseJSONConfig(data)
+	case ".yaml", ".yml":
+		values, err = parseYAMLConfig(data)
+	default:
+		return nil, fmt.Errorf("%s: unknown config format, expected .json, .yaml or .yml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return values, nil
+}
+
+// presetFlags are the flags given on the command line or in the environment, which
+// config files never override. They're captured when a config file is first applied,
+// so that reloading it doesn't mistake its own earlier values for overrides
+var presetFlags map[string]bool
+
+// applyFlagValues sets each named flag not already set on the command line,
+// calling Set once per value so repeatable flags can be given lists
+func applyFlagValues(values map[string][]string) error {
+	if presetFlags == nil {
+		presetFlags = map[string]bool{}
+		flag.Visit(func(f *flag.Flag) {
+			presetFlags[f.Name] = true
+		})
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if flag.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("unknown key %q", name)
+		}
+		if presetFlags[name] {
+			continue
+		}
+		for _, value := range values[name] {
+			if err := flag.Set(name, value); err != nil {
+				return fmt.Errorf("invalid value %q for %q: %w", value, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func parseJSONConfig(data []byte) (map[string][]string, error) {
+	var raw map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	values := map[string][]string{}
+	for name, v := range raw {
+		items := []interface{}{v}
+		if list, ok := v.([]interface{}); ok {
+			items = list
+		}
+		for _, item := range items {
+			switch item := item.(type) {
+			case string:
+				values[name] = append(values[name], item)
+			case json.Number, bool:
+				values[name] = append(values[name], fmt.Sprint(item))
+			default:
+				return nil, fmt.Errorf("invalid type for %q: expected a string, number, boolean or list of them", name)
+			}
+		}
+	}
+	return values, nil
+}
+
+// parseYAMLConfig reads the flat subset of YAML needed for flag values: top-level
+// scalar keys, with lists given either inline ([a, b]) or as "- item" lines
+func parseYAMLConfig(data []byte) (map[string][]string, error) {
+	values := map[string][]string{}
+	listKey := ""
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if listKey == "" {
+				return nil, fmt.Errorf("line %d: list item without a key", i+1)
+			}
+			v, err := yamlScalar(strings.TrimPrefix(trimmed, "-"))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			values[listKey] = append(values[listKey], v)
+			continue
+		}
+		if line != trimmed {
+			return nil, fmt.Errorf("line %d: nested values are not supported", i+1)
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", i+1)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		listKey = ""
+		switch {
+		case value == "" || strings.HasPrefix(value, "#"):
+			listKey = key
+			values[key] = nil
+		case strings.HasPrefix(value, "["):
+			end := strings.LastIndex(value, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated list", i+1)
+			}
+			values[key] = []string{}
+			for _, item := range strings.Split(value[1:end], ",") {
+				if strings.TrimSpace(item) == "" {
+					continue
+				}
+				v, err := yamlScalar(item)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", i+1, err)
+				}
+				values[key] = append(values[key], v)
+			}
+		default:
+			v, err := yamlScalar(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			values[key] = []string{v}
+		}
+	}
+	return values, nil
+}
+
+// yamlScalar unquotes a YAML scalar and strips trailing comments from plain ones
+func yamlScalar(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, `"`):
+		end := strings.LastIndex(s, `"`)
+		if end == 0 {
+			return "", errors.New("unterminated string")
+		}
+		return strconv.Unquote(s[:end+1])
+	case strings.HasPrefix(s, "'"):
+		end := strings.LastIndex(s, "'")
+		if end == 0 {
+			return "", errors.New("unterminated string")
+		}
+		return strings.ReplaceAll(s[1:end], "''", "'"), nil
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	if s == "~" || s == "null" {
+		return "", errors.New("null values are not supported")
+	}
+	return s, nil
+}
+
+// envName maps a flag to its environment variable, e.g. -sslport to GOMOOSE_SSLPORT
+// and -auth-user to GOMOOSE_AUTH_USER
+func envName(flagName string) string {
+	return "GOMOOSE_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// loadEnv applies GOMOOSE_* environment variables to flags not given on the command line
+func loadEnv() error {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] || f.Name == "version" {
+			return
+		}
+		name := envName(f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		// flag.Set also marks the flag as set, so config files won't override it
+		if setErr := flag.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %w", value, name, setErr)
+		}
+	})
+	return err
+}
+
+// redactedFlags hold secrets or key paths, whose values are hidden when logging the configuration
+var redactedFlags = map[string]bool{"key": true, "auth-pass": true, "sni": true, "shutdown-token": true}
+
+// effectiveConfig describes the flag values in effect after the environment and
+// config file are applied: those changed from their defaults, or every flag if all is set
+func effectiveConfig(all bool) string {
+	var parts []string
+	flag.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if !all && value == f.DefValue {
+			return
+		}
+		if redactedFlags[f.Name] && value != "" {
+			value = "[redacted]"
+		}
+		if value == "" || strings.ContainsAny(value, " \t\"") {
+			value = strconv.Quote(value)
+		}
+		parts = append(parts, "-"+f.Name+"="+value)
+	})
+	if len(parts) == 0 {
+		return "defaults"
+	}
+	return strings.Join(parts, " ")
+}
+
+// stringList collects the values of a repeatable flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseYAMLConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name, yaml string
+		want       map[string][]string
+	}{
+		{"scalars", "port: 8080\ngzip: true\ndir: /srv/www\n", map[string][]string{"port": {"8080"}, "gzip": {"true"}, "dir": {"/srv/www"}}},
+		{"document marker and comments", "---\n# settings\nport: 8080 # inline\n\n", map[string][]string{"port": {"8080"}}},
+		{"double quotes", `header: "X-A: \"b\" # not a comment"` + "\n", map[string][]string{"header": {`X-A: "b" # not a comment`}}},
+		{"single quotes", "csp: 'default-src ''self'''\n", map[string][]string{"csp": {"default-src 'self'"}}},
+		{"inline list", "block: [\"*.env\", secrets/*, 'a b']\n", map[string][]string{"block": {"*.env", "secrets/*", "a b"}}},
+		{"empty inline list", "block: []\n", map[string][]string{"block": {}}},
+		{"dash list", "mount:\n  - docs=/srv/docs\n  - \"media=/srv/media\"\n# between\n  - x=/y # comment\nport: 80\n", map[string][]string{"mount": {"docs=/srv/docs", "media=/srv/media", "x=/y"}, "port": {"80"}}},
+		{"windows line endings", "port: 8080\r\ngzip: true\r\n", map[string][]string{"port": {"8080"}, "gzip": {"true"}}},
+	} {
+		got, err := parseYAMLConfig([]byte(tc.yaml))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct{ name, yaml string }{
+		{"nested map", "tls:\n  min: 1.2\n"},
+		{"indented key", "port: 80\n  gzip: true\n"},
+		{"list item without a key", "- a\n"},
+		{"missing colon", "port 8080\n"},
+		{"unterminated double quote", "csp: \"abc\n"},
+		{"unterminated single quote", "csp: 'abc\n"},
+		{"unterminated list", "block: [a, b\n"},
+		{"null", "dir: ~\n"},
+		{"bad escape", `csp: "\q"` + "\n"},
+	} {
+		if got, err := parseYAMLConfig([]byte(tc.yaml)); err == nil {
+			t.Errorf("%s: expected an error, got %q", tc.name, got)
+		}
+	}
+}
+
+func TestParseJSONConfig(t *testing.T) {
+	got, err := parseJSONConfig([]byte(`{"port": 8080, "gzip": true, "dir": "/srv", "block": ["*.env", "*.key"], "rate-limit": 2.5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"port": {"8080"}, "gzip": {"true"}, "dir": {"/srv"}, "block": {"*.env", "*.key"}, "rate-limit": {"2.5"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	for _, bad := range []string{`{"tls": {"min": "1.2"}}`, `{"dir": null}`, `{"block": [["a"]]}`, `[1, 2]`, `{"port": 80`} {
+		if got, err := parseJSONConfig([]byte(bad)); err == nil {
+			t.Errorf("%s: expected an error, got %q", bad, got)
+		}
+	}
+}
+
+// restoreFlags puts back the flags config loading tests change, and forgets the
+// command-line flags captured by the first load
+func restoreFlags(t *testing.T) {
+	oldPort, oldGzip, oldBlocked, oldDir := port, useGzip, blocked, dir
+	t.Cleanup(func() {
+		port, useGzip, blocked, dir = oldPort, oldGzip, oldBlocked, oldDir
+		presetFlags = nil
+	})
+	presetFlags = nil
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	restoreFlags(t)
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	if err := loadConfigFile(write("a.yaml", "port: 8081\ngzip: true\nblock:\n  - '*.env'\n  - '*.key'\n")); err != nil {
+		t.Fatal(err)
+	}
+	if port != 8081 || !useGzip || !reflect.DeepEqual([]string(blocked), []string{"*.env", "*.key"}) {
+		t.Errorf("got port %d, gzip %t, block %q", port, useGzip, blocked)
+	}
+
+	for name, content := range map[string]string{
+		"unknown.json": `{"no-such-flag": 1}`,
+		"type.json":    `{"port": "eighty"}`,
+		"type.yaml":    "gzip: maybe\n",
+		"config.yaml":  "config: other.yaml\n",
+		"flags.toml":   "port = 80\n",
+	} {
+		if err := loadConfigFile(write(name, content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	for flagName, want := range map[string]string{
+		"port":                   "GOMOOSE_PORT",
+		"sslport":                "GOMOOSE_SSLPORT",
+		"auth-user":              "GOMOOSE_AUTH_USER",
+		"proxy-protocol-require": "GOMOOSE_PROXY_PROTOCOL_REQUIRE",
+	} {
+		if got := envName(flagName); got != want {
+			t.Errorf("envName(%q) = %q, want %q", flagName, got, want)
+		}
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	restoreFlags(t)
+	oldSSLPort, oldHost, oldUser, oldHSTS, oldTimeout := sslPort, host, authUser, hsts, shutdownTimeout
+	t.Cleanup(func() {
+		sslPort, host, authUser, hsts, shutdownTimeout = oldSSLPort, oldHost, oldUser, oldHSTS, oldTimeout
+	})
+
+	// a flag given on the command line beats the environment
+	if err := flag.Set("host", "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOMOOSE_HOST", "0.0.0.0")
+	t.Setenv("GOMOOSE_SSLPORT", "8443")
+	t.Setenv("GOMOOSE_AUTH_USER", "alice")
+	t.Setenv("GOMOOSE_HSTS", "true")
+	t.Setenv("GOMOOSE_SHUTDOWN_TIMEOUT", "5s")
+	if err := loadEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if host != "127.0.0.1" || sslPort != 8443 || authUser != "alice" || !hsts || shutdownTimeout != 5*time.Second {
+		t.Errorf("got host %q, sslport %d, auth-user %q, hsts %t, shutdown-timeout %v", host, sslPort, authUser, hsts, shutdownTimeout)
+	}
+
+	// and the environment beats the config file
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(config, []byte("sslport: 9443\nauth-user: bob\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfigFile(config); err != nil {
+		t.Fatal(err)
+	}
+	if sslPort != 8443 || authUser != "alice" {
+		t.Errorf("config file overrode the environment: sslport %d, auth-user %q", sslPort, authUser)
+	}
+}
+
+func TestLoadEnvErrors(t *testing.T) {
+	// flags set by other tests count as given on the command line, so these use others
+	oldConns, oldHTTP2, oldRate := maxConns, noHTTP2, rateLimit
+	t.Cleanup(func() { maxConns, noHTTP2, rateLimit = oldConns, oldHTTP2, oldRate })
+	for name, value := range map[string]string{
+		"GOMOOSE_MAX_CONNS":  "eighty",
+		"GOMOOSE_NO_HTTP2":   "maybe",
+		"GOMOOSE_RATE_LIMIT": "1.2.3",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			err := loadEnv()
+			if err == nil || !strings.Contains(err.Error(), name) {
+				t.Errorf("got %v, want an error naming %s", err, name)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connLimitLogInterval is the least time between warnings that -max-conns was reached
+const connLimitLogInterval = time.Minute
+
+// connLimiter caps the connections open across every listener it wraps, so the HTTP
+// and SSL listeners share one limit. Accepting blocks while the limit is reached
+type connLimiter struct {
+	max     int
+	slots   chan struct{}
+	hits    atomic.Int64
+	lastLog atomic.Int64
+}
+
+func newConnLimiter(max int) *connLimiter {
+	return &connLimiter{max: max, slots: make(chan struct{}, max)}
+}
+
+// wrap returns ln limited to the limiter's shared connection count
+func (l *connLimiter) wrap(ln net.Listener) net.Listener {
+	return &limitListener{Listener: ln, limiter: l, done: make(chan struct{})}
+}
+
+// full records that a connection had to wait for a slot, warning at most once per
+// connLimitLogInterval with how often it happened since the last warning
+func (l *connLimiter) full() {
+	hits := l.hits.Add(1)
+	now := time.Now().UnixNano()
+	last := l.lastLog.Load()
+	if now-last < int64(connLimitLogInterval) || !l.lastLog.CompareAndSwap(last, now) {
+		return
+	}
+	l.hits.Add(-hits)
+	slog.Warn("Connection limit reached, new connections are waiting", "max", l.max, "times", hits)
+}
+
+type limitListener struct {
+	net.Listener
+	limiter   *connLimiter
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (ln *limitListener) Accept() (net.Conn, error) {
+	select {
+	case ln.limiter.slots <- struct{}{}:
+	default:
+		ln.limiter.full()
+		select {
+		case ln.limiter.slots <- struct{}{}:
+		case <-ln.done:
+			return nil, net.ErrClosed
+		}
+	}
+	c, err := ln.Listener.Accept()
+	if err != nil {
+		<-ln.limiter.slots
+		return nil, err
+	}
+	return &limitConn{Conn: c, slots: ln.limiter.slots}, nil
+}
+
+func (ln *limitListener) Close() error {
+	err := ln.Listener.Close()
+	ln.closeOnce.Do(func() { close(ln.done) })
+	return err
+}
+
+// limitConn frees its slot the first time it's closed
+type limitConn struct {
+	net.Conn
+	slots       chan struct{}
+	releaseOnce sync.Once
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.releaseOnce.Do(func() { <-c.slots })
+	return err
+}
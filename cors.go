@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsPolicy is what corsHandler allows cross-origin requests to do
+type corsPolicy struct {
+	origins     []string
+	credentials bool
+	methods     []string
+	headers     []string
+	maxAge      time.Duration
+}
+
+// corsHandler adds CORS headers, allowing any origin when the policy lists none or *,
+// and answers preflight requests without passing them on to h. Preflights are
+// allowed the policy's methods and headers, or whatever headers they ask for
+func corsHandler(h http.Handler, policy corsPolicy) http.Handler {
+	allowed := map[string]bool{}
+	for _, origin := range policy.origins {
+		if origin == "*" {
+			// an explicit wildcard allows any origin, like an empty list
+			clear(allowed)
+			break
+		}
+		allowed[origin] = true
+	}
+	methods := strings.Join(policy.methods, ", ")
+	headers := strings.Join(policy.headers, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		origin := r.Header.Get("Origin")
+		if len(allowed) > 0 {
+			header.Add("Vary", "Origin")
+		}
+		if origin == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		switch {
+		case len(allowed) == 0:
+			header.Set("Access-Control-Allow-Origin", "*")
+		case allowed[origin]:
+			header.Set("Access-Control-Allow-Origin", origin)
+		default:
+			h.ServeHTTP(w, r)
+			return
+		}
+		if policy.credentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			header.Set("Access-Control-Allow-Methods", methods)
+			if headers != "" {
+				header.Set("Access-Control-Allow-Headers", headers)
+			} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+				header.Set("Access-Control-Allow-Headers", requested)
+				header.Add("Vary", "Access-Control-Request-Headers")
+			}
+			if policy.maxAge > 0 {
+				header.Set("Access-Control-Max-Age", strconv.Itoa(int(policy.maxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// corsRequest serves a request through corsHandler, reporting whether it reached the handler
+func corsRequest(policy corsPolicy, method, origin string, headers map[string]string) (*httptest.ResponseRecorder, bool) {
+	reached := false
+	handler := corsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		w.Write([]byte("ok"))
+	}), policy)
+	req := httptest.NewRequest(method, "/font.woff2", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec, reached
+}
+
+func TestCORSOrigins(t *testing.T) {
+	anyOrigin := corsPolicy{methods: []string{"GET", "HEAD", "OPTIONS"}}
+	listed := corsPolicy{origins: []string{"https://a.example", "https://b.example"}, methods: []string{"GET"}}
+	for _, tc := range []struct {
+		name       string
+		policy     corsPolicy
+		origin     string
+		wantOrigin string
+		wantVary   bool
+	}{
+		{"any origin", anyOrigin, "https://x.example", "*", false},
+		{"any, no Origin", anyOrigin, "", "", false},
+		{"listed origin", listed, "https://b.example", "https://b.example", true},
+		{"unlisted origin", listed, "https://evil.example", "", true},
+		{"origin prefix", listed, "https://a.example.evil", "", true},
+		{"listed, no Origin", listed, "", "", true},
+	} {
+		rec, reached := corsRequest(tc.policy, http.MethodGet, tc.origin, nil)
+		if !reached || rec.Body.String() != "ok" {
+			t.Errorf("%s: request wasn't served", tc.name)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tc.wantOrigin {
+			t.Errorf("%s: got Access-Control-Allow-Origin %q, want %q", tc.name, got, tc.wantOrigin)
+		}
+		// caches must key on Origin whenever the answer depends on it
+		if got := rec.Header().Get("Vary") == "Origin"; got != tc.wantVary {
+			t.Errorf("%s: got Vary %q", tc.name, rec.Header().Get("Vary"))
+		}
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	policy := corsPolicy{origins: []string{"https://a.example"}, credentials: true, methods: []string{"GET", "PUT"}, maxAge: 10 * time.Minute}
+	preflight := map[string]string{"Access-Control-Request-Method": "PUT", "Access-Control-Request-Headers": "X-Custom"}
+
+	rec, reached := corsRequest(policy, http.MethodOptions, "https://a.example", preflight)
+	if reached {
+		t.Error("preflight reached the handler")
+	}
+	want := map[string]string{
+		"Access-Control-Allow-Origin":      "https://a.example",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     "GET, PUT",
+		"Access-Control-Allow-Headers":     "X-Custom",
+		"Access-Control-Max-Age":           "600",
+	}
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	for name, value := range want {
+		if got := rec.Header().Get(name); got != value {
+			t.Errorf("got %s %q, want %q", name, got, value)
+		}
+	}
+
+	// configured headers replace the requested ones
+	policy.headers = []string{"Content-Type"}
+	rec, _ = corsRequest(policy, http.MethodOptions, "https://a.example", preflight)
+	if got := rec.Header().Get("Access-Control-Allow-Headers"); got != "Content-Type" {
+		t.Errorf("got Access-Control-Allow-Headers %q, want %q", got, "Content-Type")
+	}
+
+	// preflights from other origins, and plain OPTIONS requests, go to the handler
+	if rec, reached := corsRequest(policy, http.MethodOptions, "https://evil.example", preflight); !reached || rec.Header().Get("Access-Control-Allow-Methods") != "" {
+		t.Error("preflight from an unlisted origin was answered")
+	}
+	if _, reached := corsRequest(policy, http.MethodOptions, "https://a.example", nil); !reached {
+		t.Error("OPTIONS without Access-Control-Request-Method was treated as a preflight")
+	}
+}
+
+func TestCORSWildcardOrigin(t *testing.T) {
+	for _, origins := range [][]string{{"*"}, {"https://a.example", "*"}} {
+		rec, _ := corsRequest(corsPolicy{origins: origins}, http.MethodGet, "https://x.example", nil)
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+			t.Errorf("-cors-origin %q: got Access-Control-Allow-Origin %q, want *", origins, got)
+		}
+		if vary := rec.Header().Get("Vary"); vary != "" {
+			t.Errorf("-cors-origin %q: got Vary %q for a response that doesn't depend on Origin", origins, vary)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// downloadWriter marks successful responses as attachments named filename
+type downloadWriter struct {
+	http.ResponseWriter
+	filename    string
+	wroteHeader bool
+}
+
+func (w *downloadWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if code >= 200 && code < 300 {
+			// non-ASCII names are encoded as filename*=utf-8''..., per RFC 5987
+			w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": w.filename}))
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *downloadWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *downloadWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// downloadHandler has browsers download files with any of exts (like .zip) rather
+// than display them, saved under the name from the URL path
+func downloadHandler(h http.Handler, exts []string) http.Handler {
+	download := map[string]bool{}
+	for _, ext := range exts {
+		download["."+strings.ToLower(strings.TrimPrefix(ext, "."))] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Base(r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/") || !download[strings.ToLower(path.Ext(name))] {
+			h.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(&downloadWriter{ResponseWriter: w, filename: name}, r)
+	})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDownloadHandler(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"report.PDF":             "pdf",
+		"naïve.zip":              "zip",
+		"a b\".zip":              "zip",
+		"page.html":              "<p>page</p>",
+		"archive.zip/index.html": "<p>index</p>",
+	})
+	handler := downloadHandler(http.FileServer(http.Dir(dir)), []string{"zip", ".pdf"})
+
+	for _, tc := range []struct {
+		path        string
+		code        int
+		disposition string
+	}{
+		{"/report.PDF", http.StatusOK, `attachment; filename=report.PDF`},
+		{"/na%C3%AFve.zip", http.StatusOK, `attachment; filename*=utf-8''na%C3%AFve.zip`},
+		{"/a%20b%22.zip", http.StatusOK, `attachment; filename="a b\".zip"`},
+		{"/page.html", http.StatusOK, ""},
+		{"/missing.zip", http.StatusNotFound, ""},
+		{"/archive.zip/", http.StatusOK, ""},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rec.Code != tc.code || rec.Header().Get("Content-Disposition") != tc.disposition {
+			t.Errorf("GET %s: got %d with Content-Disposition %q, want %d with %q", tc.path, rec.Code, rec.Header().Get("Content-Disposition"), tc.code, tc.disposition)
+		}
+	}
+}
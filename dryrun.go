@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"os"
+)
+
+// checkDir confirms dir is a directory whose entries can be listed
+func checkDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if _, err := f.ReadDir(1); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// dryRun checks the served directories and SSL certificate without opening any
+// listeners, printing a summary of what would be served
+func dryRun(srv *Server, dirs []string) error {
+	for _, dir := range dirs {
+		if err := checkDir(dir); err != nil {
+			return fmt.Errorf("unable to read directory: %w", err)
+		}
+		fmt.Println("Directory OK:", dir)
+	}
+	if srv.httpServer != nil {
+		addrs := srv.httpAddrs
+		if len(addrs) == 0 {
+			addrs = []string{srv.httpServer.Addr}
+		}
+		for _, addr := range addrs {
+			fmt.Println("HTTP would listen on", addr)
+		}
+	}
+	if srv.tlsServer != nil && srv.certificate != nil {
+		fmt.Println("SSL would listen on", srv.tlsServer.Addr, "with cert from", srv.certSource)
+	} else if srv.tlsServer != nil && srv.acme != nil {
+		fmt.Println("SSL would listen on", srv.tlsServer.Addr, "with ACME certificates cached in", acmeCache)
+	} else if srv.tlsServer != nil {
+		if _, err := tls.LoadX509KeyPair(srv.sslCert, srv.sslKey); err != nil {
+			return fmt.Errorf("unable to load SSL certificate: %w", err)
+		}
+		fmt.Println("SSL would listen on", srv.tlsServer.Addr, "with cert", srv.sslCert)
+	}
+	if srv.h3Server != nil {
+		fmt.Println("HTTP/3 would listen on", srv.tlsServer.Addr, "(UDP)")
+	}
+	if srv.httpServer == nil && srv.tlsServer == nil {
+		return fmt.Errorf("no listeners enabled")
+	}
+	fmt.Println("Configuration OK")
+	return nil
+}
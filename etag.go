@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// etagCacheSize bounds the number of file hashes kept by strongETagHandler
+const etagCacheSize = 10000
+
+type etagEntry struct {
+	modTime time.Time
+	size    int64
+	etag    string
+}
+
+// etagCache remembers file hashes by path, invalidated when a file's mtime or size changes
+type etagCache struct {
+	mu      sync.Mutex
+	entries map[string]etagEntry
+}
+
+func (c *etagCache) get(name string, info fs.FileInfo) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[name]
+	if !ok || !e.modTime.Equal(info.ModTime()) || e.size != info.Size() {
+		return "", false
+	}
+	return e.etag, true
+}
+
+func (c *etagCache) put(name string, info fs.FileInfo, etag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[name]; !ok && len(c.entries) >= etagCacheSize {
+		// evict an arbitrary entry; map iteration order is random
+		for old := range c.entries {
+			delete(c.entries, old)
+			break
+		}
+	}
+	c.entries[name] = etagEntry{info.ModTime(), info.Size(), etag}
+}
+
+// hashFile returns a strong ETag of the contents of name in fsys
+func hashFile(fsys fs.FS, name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return `"` + base64.RawURLEncoding.EncodeToString(hash.Sum(nil)) + `"`, nil
+}
+
+// strongETagHandler sets an ETag hashed from the contents of files in fsys, which
+// http.FileServer then uses to answer If-None-Match and If-Range
+func strongETagHandler(h http.Handler, fsys fs.FS) http.Handler {
+	cache := &etagCache{entries: map[string]etagEntry{}}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || strings.HasSuffix(r.URL.Path, "/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		info, err := fs.Stat(fsys, name)
+		if err != nil || !info.Mode().IsRegular() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		etag, ok := cache.get(name, info)
+		if !ok {
+			if etag, err = hashFile(fsys, name); err != nil {
+				h.ServeHTTP(w, r)
+				return
+			}
+			cache.put(name, info, etag)
+		}
+		w.Header().Set("ETag", etag)
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+type cachedFile struct {
+	name    string
+	modTime time.Time
+	data    []byte
+}
+
+// fileCache keeps file contents in memory up to a byte budget, evicting the least
+// recently used files to make room
+type fileCache struct {
+	mu      sync.RWMutex
+	maxSize int64
+	size    int64
+	order   *list.List // most recently used at the front
+	files   map[string]*list.Element
+}
+
+func newFileCache(maxSize int64) *fileCache {
+	return &fileCache{maxSize: maxSize, order: list.New(), files: map[string]*list.Element{}}
+}
+
+// get returns the cached contents of name if they're as recent as modTime
+func (c *fileCache) get(name string, modTime time.Time) ([]byte, bool) {
+	c.mu.RLock()
+	el, ok := c.files[name]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	f := el.Value.(*cachedFile)
+	if !f.modTime.Equal(modTime) {
+		return nil, false
+	}
+	c.mu.Lock()
+	// el may have been evicted since the read lock was released
+	if c.files[name] == el {
+		c.order.MoveToFront(el)
+	}
+	c.mu.Unlock()
+	return f.data, true
+}
+
+func (c *fileCache) put(name string, modTime time.Time, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.files[name]; ok {
+		c.remove(el)
+	}
+	for c.size+int64(len(data)) > c.maxSize && c.order.Len() > 0 {
+		c.remove(c.order.Back())
+	}
+	c.files[name] = c.order.PushFront(&cachedFile{name, modTime, data})
+	c.size += int64(len(data))
+}
+
+func (c *fileCache) remove(el *list.Element) {
+	f := c.order.Remove(el).(*cachedFile)
+	delete(c.files, f.name)
+	c.size -= int64(len(f.data))
+}
+
+// fileCacheHandler serves files from fsys no larger than maxFileSize out of cache,
+// reading them into it on first request and again whenever their mtime changes
+func fileCacheHandler(h http.Handler, fsys fs.FS, cache *fileCache, maxFileSize int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// http.FileServer redirects requests for index.html, so leave those to it
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) ||
+			strings.HasSuffix(r.URL.Path, "/") || strings.HasSuffix(r.URL.Path, "/index.html") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		info, err := fs.Stat(fsys, name)
+		if err != nil || !info.Mode().IsRegular() || info.Size() > maxFileSize {
+			h.ServeHTTP(w, r)
+			return
+		}
+		data, ok := cache.get(name, info.ModTime())
+		if !ok {
+			f, err := fsys.Open(name)
+			if err != nil {
+				h.ServeHTTP(w, r)
+				return
+			}
+			data, err = io.ReadAll(io.LimitReader(f, maxFileSize+1))
+			f.Close()
+			if err != nil || int64(len(data)) > maxFileSize {
+				h.ServeHTTP(w, r)
+				return
+			}
+			cache.put(name, info.ModTime(), data)
+		}
+		http.ServeContent(w, r, name, info.ModTime(), bytes.NewReader(data))
+	})
+}
@@ -0,0 +1,16 @@
+module github.com/bluehexagons/gomoose
+
+go 1.26.0
+
+require (
+	github.com/andybalholm/brotli v1.2.5
+	github.com/quic-go/quic-go v0.63.0
+	golang.org/x/crypto v0.57.0
+	golang.org/x/net v0.58.0
+)
+
+require (
+	github.com/quic-go/qpack v0.6.0 // indirect
+	golang.org/x/sys v0.48.0 // indirect
+	golang.org/x/text v0.42.0 // indirect
+)
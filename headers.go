@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// secureHeaders are set by -secure-headers
+var secureHeaders = map[string]string{
+	"X-Content-Type-Options": "nosniff",
+	"X-Frame-Options":        "DENY",
+	"Referrer-Policy":        "no-referrer",
+}
+
+// validHeaderName reports whether name is an HTTP token
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c > '~' || c <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// validHeaderValue reports whether value is free of control characters
+func validHeaderValue(value string) bool {
+	for _, c := range value {
+		if (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// headerList collects repeated -header "Name: Value" (or name=value) flags, in order
+type headerList [][2]string
+
+func (l *headerList) String() string {
+	var parts []string
+	for _, h := range *l {
+		parts = append(parts, h[0]+": "+h[1])
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *headerList) Set(value string) error {
+	i := strings.IndexAny(value, ":=")
+	if i < 0 {
+		return fmt.Errorf(`expected "Name: Value", got %q`, value)
+	}
+	name, v := strings.TrimSpace(value[:i]), strings.TrimSpace(value[i+1:])
+	if !validHeaderName(name) {
+		return fmt.Errorf("invalid header name %q", name)
+	}
+	if !validHeaderValue(v) {
+		return fmt.Errorf("invalid value for header %s", name)
+	}
+	*l = append(*l, [2]string{http.CanonicalHeaderKey(name), v})
+	return nil
+}
+
+// responseHeaders merges the optional secure defaults with custom headers, where
+// a custom header replaces a default and an empty value removes it
+func responseHeaders(secure bool, custom headerList) http.Header {
+	headers := http.Header{}
+	if secure {
+		for name, value := range secureHeaders {
+			headers.Set(name, value)
+		}
+	}
+	for _, h := range custom {
+		if h[1] == "" {
+			headers.Del(h[0])
+		} else {
+			headers.Set(h[0], h[1])
+		}
+	}
+	return headers
+}
+
+// serverWriter sets (or with an empty value, removes) the Server header just before
+// the response is sent, replacing any set further in, like by a proxied backend
+type serverWriter struct {
+	http.ResponseWriter
+	server      string
+	wroteHeader bool
+}
+
+func (w *serverWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if w.server == "" {
+			w.Header().Del("Server")
+		} else {
+			w.Header().Set("Server", w.server)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *serverWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *serverWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// headersHandler adds headers to every response, and sets its Server header to server
+func headersHandler(h http.Handler, headers http.Header, server string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, values := range headers {
+			w.Header()[name] = values
+		}
+		h.ServeHTTP(&serverWriter{ResponseWriter: w, server: server}, r)
+	})
+}
+
+// hstsHandler tells browsers to only use HTTPS for maxAge. Browsers ignore the header
+// over plain HTTP, so it's only sent with SSL
+func hstsHandler(h http.Handler, maxAge time.Duration) http.Handler {
+	value := "max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// cspWriter adds a Content-Security-Policy header once the response is known to be HTML
+type cspWriter struct {
+	http.ResponseWriter
+	name        string
+	policy      string
+	wroteHeader bool
+}
+
+func (w *cspWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+			w.Header().Set(w.name, w.policy)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cspWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cspWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// cspHandler applies policy to HTML responses, in report-only mode if reportOnly is set
+func cspHandler(h http.Handler, policy string, reportOnly bool) http.Handler {
+	name := "Content-Security-Policy"
+	if reportOnly {
+		name = "Content-Security-Policy-Report-Only"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(&cspWriter{ResponseWriter: w, name: name, policy: policy}, r)
+	})
+}
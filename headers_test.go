@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServerHeader(t *testing.T) {
+	// stands in for a proxied backend that sets its own Server header
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "backend/1.0")
+		w.Write([]byte("ok"))
+	})
+	for _, tc := range []struct {
+		server string
+		want   []string
+	}{
+		{"gomoose", []string{"gomoose"}},
+		{"", nil},
+	} {
+		rec := httptest.NewRecorder()
+		headersHandler(backend, http.Header{}, tc.server).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		got := rec.Header().Values("Server")
+		if len(got) != len(tc.want) || (len(got) > 0 && got[0] != tc.want[0]) {
+			t.Errorf("-server-header %q: got Server %q, want %q", tc.server, got, tc.want)
+		}
+	}
+}
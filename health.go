@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// healthHandler answers health checks with a status and the number of open connections
+// from connections (if not nil)
+func healthHandler(connections func() int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodHead {
+			return
+		}
+		if connections == nil {
+			w.Write([]byte(`{"status":"ok"}` + "\n"))
+			return
+		}
+		w.Write([]byte(`{"status":"ok","connections":` + strconv.FormatInt(connections(), 10) + "}\n"))
+	})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newHTTP3Server returns an HTTP/3 server for handler, which Start serves over UDP on
+// the SSL port with the SSL server's TLS settings
+func newHTTP3Server(handler http.Handler) *http3.Server {
+	return &http3.Server{
+		Handler:        handler,
+		MaxHeaderBytes: maxHeaderBytes,
+		IdleTimeout:    idleTimeout,
+	}
+}
+
+// altSvcHandler advertises h3 to clients of the SSL server with an Alt-Svc header,
+// so browsers switch to HTTP/3 for later requests
+func altSvcHandler(h http.Handler, h3 *http3.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && r.ProtoMajor < 3 {
+			// there's nothing to advertise until the UDP listener is running
+			h3.SetQUICHeaders(w.Header())
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// listenHTTP3 binds the UDP port matching the SSL listener at addr
+func listenHTTP3(addr net.Addr) (net.PacketConn, error) {
+	tcp := addr.(*net.TCPAddr)
+	return net.ListenPacket("udp", net.JoinHostPort(tcp.IP.String(), strconv.Itoa(tcp.Port)))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// parseList splits a comma-separated flag value, dropping empty entries
+func parseList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// findIndex returns the first of names that exists as a file in the directory dir
+func findIndex(fs http.FileSystem, dir string, names []string) (string, bool) {
+	for _, name := range names {
+		f, err := fs.Open(path.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		info, err := f.Stat()
+		f.Close()
+		if err == nil && !info.IsDir() {
+			return path.Join(dir, name), true
+		}
+	}
+	return "", false
+}
+
+// indexHandler serves the first existing index file from names for directory requests
+func indexHandler(h http.Handler, fs http.FileSystem, names []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		name, ok := findIndex(fs, path.Clean(r.URL.Path), names)
+		// http.FileServer already serves index.html itself, and redirects requests for it
+		if !ok || path.Base(name) == "index.html" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		f, err := fs.Open(name)
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+	})
+}
+
+// noListingHandler responds 403 to requests for directories without an index file
+func noListingHandler(h http.Handler, fs http.FileSystem, names []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		dir := path.Clean(r.URL.Path)
+		f, err := fs.Open(dir)
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		info, err := f.Stat()
+		f.Close()
+		if err != nil || !info.IsDir() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if _, ok := findIndex(fs, dir, names); ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	})
+}
+
+// openRootFile checks that name is a file within dir, without following paths or
+// symlinks out of it, returning the directory to serve it from
+func openRootFile(dir, name string) (fs.FS, error) {
+	if !fs.ValidPath(name) {
+		return nil, fmt.Errorf("%q must be a relative path within the served directory", name)
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	info, err := root.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", name)
+	}
+	return root.FS(), nil
+}
+
+// rootFileHandler serves the file name from fsys for GET and HEAD requests for
+// exactly "/", whether or not there's an index file
+func rootFileHandler(h http.Handler, fsys fs.FS, name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		f, err := fsys.Open(name)
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil || info.IsDir() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		rs, ok := f.(io.ReadSeeker)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		http.ServeContent(w, r, info.Name(), info.ModTime(), rs)
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// cidrList collects repeated CIDR range flags, accepting bare IPs as single addresses
+type cidrList []*net.IPNet
+
+func (l *cidrList) String() string {
+	var parts []string
+	for _, n := range *l {
+		parts = append(parts, n.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *cidrList) Set(value string) error {
+	for _, item := range parseList(value) {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return fmt.Errorf("invalid IP or CIDR range %q", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			*l = append(*l, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return fmt.Errorf("invalid IP or CIDR range %q", item)
+		}
+		*l = append(*l, n)
+	}
+	return nil
+}
+
+// contains reports whether ip is in any of the ranges
+func (l cidrList) contains(ip net.IP) bool {
+	for _, n := range l {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipFilterHandler responds 403 to clients in deny, or outside allow when it's
+// non-empty. Deny takes precedence over allow
+func ipFilterHandler(h http.Handler, allow, deny cidrList) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := net.ParseIP(clientIP(r))
+		if ip == nil || deny.contains(ip) || (len(allow) > 0 && !allow.contains(ip)) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listingTemplate renders HTML listings, replaced by -autoindex-template if given
+var listingTemplate = template.Must(template.New("listing").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width">
+<title>Index of {{.Path}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; min-width: 50%; }
+th, td { text-align: left; padding: 0.25em 1em 0.25em 0; }
+th a { color: inherit; }
+td.size { text-align: right; font-variant-numeric: tabular-nums; }
+tr:hover td { background: #f3f3f3; }
+a { text-decoration: none; color: #0645ad; }
+</style>
+</head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<table>
+<thead><tr>
+<th><a href="?sort=name&amp;order={{.Order "name"}}">Name</a></th>
+<th><a href="?sort=size&amp;order={{.Order "size"}}">Size</a></th>
+<th><a href="?sort=modified&amp;order={{.Order "modified"}}">Modified</a></th>
+</tr></thead>
+<tbody>
+{{if ne .Path "/"}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{.Href}}">{{.Name}}</a></td><td class="size">{{.Size}}</td><td>{{.Modified}}</td></tr>
+{{end}}</tbody>
+</table>
+</body>
+</html>
+`))
+
+// listingEntry is a file in a listing. Templates can also call IsDir, Bytes and
+// ModTime for the raw metadata behind Size and Modified
+type listingEntry struct {
+	Name     string
+	Href     string
+	Size     string
+	Modified string
+	isDir    bool
+	bytes    int64
+	modTime  time.Time
+}
+
+func (e listingEntry) IsDir() bool        { return e.isDir }
+func (e listingEntry) Bytes() int64       { return e.bytes }
+func (e listingEntry) ModTime() time.Time { return e.modTime }
+
+// listing is the data listing templates are rendered with
+type listing struct {
+	Path    string
+	Entries []listingEntry
+	sort    string
+	desc    bool
+}
+
+// Order returns the order a column's header link should request, flipping the current one
+func (l *listing) Order(column string) string {
+	if column == l.sort && !l.desc {
+		return "desc"
+	}
+	return "asc"
+}
+
+// loadListingTemplate parses the HTML template file at path, for rendering listings
+// in place of the built-in template
+func loadListingTemplate(path string) (*template.Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(filepath.Base(path)).Parse(string(data))
+}
+
+// etag returns a weak ETag hashed from the listing's sorted entries and their
+// metadata, which changes whenever the rendered listing would
+func (l *listing) etag() string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00%t\x00", l.Path, l.sort, l.desc)
+	for _, e := range l.Entries {
+		fmt.Fprintf(hash, "%s\x00%d\x00%d\x00", e.Name, e.bytes, e.modTime.UnixNano())
+	}
+	return `W/"` + base64.RawURLEncoding.EncodeToString(hash.Sum(nil)) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, comparing weakly
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// formatSize renders a byte count in human-readable units
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// readListing reads the directory dir (a URL path) from fsys, sorted by column and
+// leaving out entries whose URL path hide reports true for
+func readListing(fsys fs.FS, dir, column string, desc bool, hide func(string) bool) (*listing, error) {
+	name := strings.TrimPrefix(dir, "/")
+	if name == "" {
+		name = "."
+	}
+	entries, err := fs.ReadDir(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	l := &listing{Path: dir, sort: column, desc: desc}
+	for _, entry := range entries {
+		if hide(path.Join(dir, entry.Name())) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		e := listingEntry{
+			Name:     entry.Name(),
+			Href:     "./" + url.PathEscape(entry.Name()),
+			Modified: info.ModTime().Format("2006-01-02 15:04"),
+			isDir:    entry.IsDir(),
+			bytes:    info.Size(),
+			modTime:  info.ModTime(),
+		}
+		if e.isDir {
+			e.Name += "/"
+			e.Href += "/"
+		} else {
+			e.Size = formatSize(e.bytes)
+		}
+		l.Entries = append(l.Entries, e)
+	}
+	sort.SliceStable(l.Entries, func(i, j int) bool {
+		a, b := l.Entries[i], l.Entries[j]
+		if a.isDir != b.isDir {
+			return a.isDir
+		}
+		if desc {
+			a, b = b, a
+		}
+		switch column {
+		case "size":
+			return a.bytes < b.bytes
+		case "modified":
+			return a.modTime.Before(b.modTime)
+		default:
+			return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+		}
+	})
+	return l, nil
+}
+
+// prettyListingHandler renders an HTML listing for directories in fsys without an
+// index file, answering If-None-Match with a 304 while the directory is unchanged
+func prettyListingHandler(h http.Handler, fsys fs.FS, names []string, hide func(string) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		dir := path.Clean(r.URL.Path)
+		if _, ok := findIndex(http.FS(fsys), dir, names); ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		query := r.URL.Query()
+		l, err := readListing(fsys, dir, query.Get("sort"), query.Get("order") == "desc", hide)
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if dir != "/" {
+			l.Path = dir + "/"
+		}
+		etag := l.etag()
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		var buf bytes.Buffer
+		if err := listingTemplate.Execute(&buf, l); err != nil {
+			slog.Error("Unable to render directory listing", "path", dir, "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		w.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			buf.WriteTo(w)
+		}
+	})
+}
+
+type jsonListingEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modtime"`
+	IsDir   bool      `json:"isdir"`
+}
+
+// jsonListingHandler lists directories in fsys as JSON for requests with ?format=json,
+// sorted with the same sort and order parameters as the HTML listing
+func jsonListingHandler(h http.Handler, fsys fs.FS, hide func(string) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if !strings.HasSuffix(r.URL.Path, "/") || query.Get("format") != "json" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		l, err := readListing(fsys, path.Clean(r.URL.Path), query.Get("sort"), query.Get("order") == "desc", hide)
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		entries := make([]jsonListingEntry, 0, len(l.Entries))
+		for _, e := range l.Entries {
+			entries = append(entries, jsonListingEntry{
+				Name:    strings.TrimSuffix(e.Name, "/"),
+				Size:    e.bytes,
+				ModTime: e.modTime,
+				IsDir:   e.isDir,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(entries); err != nil {
+			slog.Error("Unable to encode directory listing", "path", r.URL.Path, "err", err)
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListingETag(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"a.txt":     "a",
+		"sub/b.txt": "b",
+	})
+	handler := prettyListingHandler(http.NotFoundHandler(), os.DirFS(dir), []string{"index.html"}, func(string) bool { return false })
+	get := func(target, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := get("/", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("GET /: got %d with ETag %q, want 200 with an ETag", first.Code, etag)
+	}
+	if rec := get("/", etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("GET / with a matching If-None-Match: got %d with %d bytes, want an empty 304", rec.Code, rec.Body.Len())
+	}
+	if rec := get("/", `"other", `+etag[2:]); rec.Code != http.StatusNotModified {
+		t.Errorf("GET / with the strong form of the ETag in a list: got %d, want 304", rec.Code)
+	}
+	if rec := get("/?sort=size", etag); rec.Code != http.StatusOK {
+		t.Errorf("GET /?sort=size with the unsorted ETag: got %d, want 200", rec.Code)
+	}
+	if rec := get("/sub/", etag); rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("GET /sub/ with the ETag of /: got %d with ETag %q, want 200 with a different ETag", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "a.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	rec := get("/", etag)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("GET / after a file changed: got %d with ETag %q, want 200 with a new ETag", rec.Code, rec.Header().Get("ETag"))
+	}
+	changed := rec.Header().Get("ETag")
+	if err := os.WriteFile(filepath.Join(dir, "c.txt"), []byte("c"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if rec := get("/", changed); rec.Code != http.StatusOK {
+		t.Errorf("GET / after a file was added: got %d, want 200", rec.Code)
+	}
+}
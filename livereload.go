@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// livereloadPath is the event stream that injected pages listen to for changes
+const livereloadPath = "/__livereload"
+
+// livereloadInterval is how often the served directories are scanned for changes
+const livereloadInterval = time.Second
+
+// livereloadScript is the script added to pages, connecting to livereloadPath under
+// prefix, the path the server is reached at through any proxy
+func livereloadScript(prefix string) string {
+	return `<script>new EventSource("` + template.JSEscapeString(prefix+livereloadPath) + `").onmessage = function() { location.reload() }</script>`
+}
+
+// livereload polls directories for changes, notifying connected pages
+type livereload struct {
+	dirs    []string
+	mu      sync.Mutex
+	clients map[chan struct{}]bool
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newLivereload(dirs []string) *livereload {
+	lr := &livereload{dirs: dirs, clients: map[chan struct{}]bool{}, done: make(chan struct{})}
+	go lr.watch()
+	return lr
+}
+
+// fingerprint summarizes the names, sizes and mtimes of every file in the directories
+func (lr *livereload) fingerprint() string {
+	var b strings.Builder
+	for _, dir := range lr.dirs {
+		filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				fmt.Fprintf(&b, "%s %d %d\n", p, info.Size(), info.ModTime().UnixNano())
+			}
+			return nil
+		})
+	}
+	return b.String()
+}
+
+func (lr *livereload) watch() {
+	last := lr.fingerprint()
+	ticker := time.NewTicker(livereloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lr.done:
+			return
+		case <-ticker.C:
+		}
+		if current := lr.fingerprint(); current != last {
+			last = current
+			lr.mu.Lock()
+			for c := range lr.clients {
+				select {
+				case c <- struct{}{}:
+				default:
+				}
+			}
+			lr.mu.Unlock()
+		}
+	}
+}
+
+// close stops watching and ends every event stream, so they don't hold up shutdown
+func (lr *livereload) close() {
+	lr.once.Do(func() {
+		close(lr.done)
+	})
+}
+
+func (lr *livereload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	rc.Flush()
+	c := make(chan struct{}, 1)
+	lr.mu.Lock()
+	lr.clients[c] = true
+	lr.mu.Unlock()
+	defer func() {
+		lr.mu.Lock()
+		delete(lr.clients, c)
+		lr.mu.Unlock()
+	}()
+	for {
+		select {
+		case <-c:
+			fmt.Fprint(w, "data: reload\n\n")
+			rc.Flush()
+		case <-r.Context().Done():
+			return
+		case <-lr.done:
+			return
+		}
+	}
+}
+
+// livereloadWriter buffers HTML responses to add the livereload script before </body>
+type livereloadWriter struct {
+	http.ResponseWriter
+	script      string
+	head        bool
+	buf         *bytes.Buffer
+	wroteHeader bool
+	status      int
+}
+
+func (w *livereloadWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.Header()
+	if code == http.StatusOK && h.Get("Content-Encoding") == "" &&
+		strings.HasPrefix(h.Get("Content-Type"), "text/html") {
+		h.Del("Accept-Ranges")
+		h.Del("ETag")
+		// HEAD responses have no page to inject into, but report the length a GET would
+		if w.head {
+			if size, err := strconv.Atoi(h.Get("Content-Length")); err == nil {
+				h.Set("Content-Length", strconv.Itoa(size+len(w.script)))
+			}
+			w.ResponseWriter.WriteHeader(code)
+			return
+		}
+		w.buf = &bytes.Buffer{}
+		w.status = code
+		h.Del("Content-Length")
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *livereloadWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.buf != nil {
+		return w.buf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *livereloadWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish writes the buffered page with the script injected
+func (w *livereloadWriter) finish() {
+	if w.buf == nil {
+		return
+	}
+	page := w.buf.Bytes()
+	i := bytes.LastIndex(bytes.ToLower(page), []byte("</body>"))
+	if i < 0 {
+		i = len(page)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(page)+len(w.script)))
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(page[:i])
+	w.ResponseWriter.Write([]byte(w.script))
+	w.ResponseWriter.Write(page[i:])
+}
+
+// mightBeHTML reports whether a request for urlPath could be answered with an HTML
+// page: directories, extensionless routes and files with an HTML extension
+func mightBeHTML(urlPath string) bool {
+	ext := path.Ext(urlPath)
+	return ext == "" || strings.HasSuffix(urlPath, "/") || strings.HasPrefix(mime.TypeByExtension(ext), "text/html")
+}
+
+// livereloadHandler adds the script that listens for changes to HTML pages from h,
+// served under prefix
+func livereloadHandler(h http.Handler, prefix string) http.Handler {
+	script := livereloadScript(prefix)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// ranges of pages would refer to the page without the script, but other files
+		// (like media being seeked through) are left alone
+		if mightBeHTML(r.URL.Path) {
+			r.Header.Del("Range")
+		}
+		lw := &livereloadWriter{ResponseWriter: w, script: script, head: r.Method == http.MethodHead}
+		h.ServeHTTP(lw, r)
+		lw.finish()
+	})
+}
+
+// livereloadEndpointHandler serves lr's event stream at livereloadPath. It must wrap
+// any compression, which would buffer the events
+func livereloadEndpointHandler(h http.Handler, lr *livereload) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == livereloadPath {
+			lr.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
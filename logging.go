@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// responseWriter records the status code and body size written through it
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type accessLogEntry struct {
+	Time      time.Time `json:"time"`
+	Remote    string    `json:"remote"`
+	User      string    `json:"user,omitempty"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Size      int64     `json:"size"`
+	Duration  float64   `json:"duration_ms"`
+	Range     string    `json:"range,omitempty"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// commonLogLine formats e in the Apache Common Log Format
+func commonLogLine(e *accessLogEntry) string {
+	user := e.User
+	if user == "" {
+		user = "-"
+	}
+	size := "-"
+	if e.Size > 0 {
+		size = strconv.FormatInt(e.Size, 10)
+	}
+	line := e.Remote + " - " + user + " [" + e.Time.Format("02/Jan/2006:15:04:05 -0700") + "] " +
+		strconv.Quote(e.Method+" "+e.Path+" "+e.Proto) + " " + strconv.Itoa(e.Status) + " " + size
+	if e.Range != "" {
+		line += " " + strconv.Quote(e.Range)
+	}
+	if e.RequestID != "" {
+		line += " " + strconv.Quote(e.RequestID)
+	}
+	return line
+}
+
+// accessLogHandler logs each request in the given format ("common" or "json"),
+// noting the requested range of partial responses when logRanges is set, and the
+// request ID when requestIDHandler wraps it
+func accessLogHandler(h http.Handler, format string, logRanges bool) http.Handler {
+	logger := log.New(logOutput, "", 0)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w}
+		h.ServeHTTP(rw, r)
+
+		e := accessLogEntry{
+			Time:      start,
+			Remote:    clientIP(r),
+			Method:    r.Method,
+			Path:      r.URL.RequestURI(),
+			Proto:     r.Proto,
+			Status:    rw.status,
+			Size:      rw.size,
+			Duration:  float64(time.Since(start).Microseconds()) / 1000,
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+			RequestID: requestID(r.Context()),
+		}
+		if e.Status == 0 {
+			e.Status = http.StatusOK
+		}
+		e.User, _, _ = r.BasicAuth()
+		if logRanges && e.Status == http.StatusPartialContent {
+			e.Range = r.Header.Get("Range")
+		}
+
+		if format == "json" {
+			line, err := json.Marshal(&e)
+			if err != nil {
+				slog.Error("Unable to encode access log entry", "err", err)
+				return
+			}
+			logger.Println(string(line))
+		} else {
+			logger.Println(commonLogLine(&e))
+		}
+	})
+}
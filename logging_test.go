@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHeadThroughMiddleware(t *testing.T) {
+	old := logOutput
+	logOutput = io.Discard
+	t.Cleanup(func() { logOutput = old })
+
+	body := strings.Repeat("head ", 1000)
+	dir := writeTestFiles(t, map[string]string{"page.txt": body})
+	files := http.FileServer(http.Dir(dir))
+
+	for _, tc := range []struct {
+		name     string
+		handler  http.Handler
+		length   string
+		encoding string
+	}{
+		{"plain", files, strconv.Itoa(len(body)), ""},
+		{"access log", accessLogHandler(files, "common", false), strconv.Itoa(len(body)), ""},
+		{"access log json", accessLogHandler(files, "json", true), strconv.Itoa(len(body)), ""},
+		// the compressed length isn't known without compressing the body
+		{"gzip", accessLogHandler(gzipHandler(files, false), "common", false), "", "gzip"},
+	} {
+		req := httptest.NewRequest(http.MethodHead, "/page.txt", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		tc.handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: got status %d", tc.name, rec.Code)
+		}
+		if rec.Body.Len() != 0 {
+			t.Errorf("%s: HEAD response has a %d byte body", tc.name, rec.Body.Len())
+		}
+		if got := rec.Header().Get("Content-Length"); got != tc.length {
+			t.Errorf("%s: got Content-Length %q, want %q", tc.name, got, tc.length)
+		}
+		if got := rec.Header().Get("Content-Encoding"); got != tc.encoding {
+			t.Errorf("%s: got Content-Encoding %q, want %q", tc.name, got, tc.encoding)
+		}
+		if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
+			t.Errorf("%s: got Content-Type %q", tc.name, got)
+		}
+	}
+}
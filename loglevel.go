@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+)
+
+var logLevels = map[string]slog.Level{
+	"error": slog.LevelError,
+	"warn":  slog.LevelWarn,
+	"info":  slog.LevelInfo,
+	"debug": slog.LevelDebug,
+}
+
+// logLevel is the most verbose level logged; it can change on SIGHUP
+var logLevel slog.LevelVar
+
+// logOutput is where access logs are written, unaffected by -log-json
+var logOutput io.Writer = os.Stderr
+
+var jsonLogging bool
+
+// resolveLogLevel picks the level from -log-level, or failing that from -quiet
+// (error) and -verbose (debug), defaulting to info
+func resolveLogLevel(name string, quiet, verbose bool) (slog.Level, error) {
+	switch {
+	case name != "":
+		level, ok := logLevels[name]
+		if !ok {
+			return 0, fmt.Errorf("invalid log level %q, expected error, warn, info or debug", name)
+		}
+		return level, nil
+	case quiet:
+		return slog.LevelError, nil
+	case verbose:
+		return slog.LevelDebug, nil
+	}
+	return slog.LevelInfo, nil
+}
+
+// setLogLevel changes the level for the JSON handler, or for slog's default
+// handler, which writes through the log package
+func setLogLevel(level slog.Level) {
+	logLevel.Set(level)
+	if !jsonLogging {
+		slog.SetLogLoggerLevel(level)
+	}
+}
+
+func logEnabled(level slog.Level) bool {
+	return level >= logLevel.Level()
+}
+
+// setupLogging switches slog to JSON lines on the log package's writer when
+// asJSON is set, keeping the default human-readable output otherwise
+func setupLogging(asJSON bool) {
+	logOutput = log.Writer()
+	if asJSON {
+		jsonLogging = true
+		// this also routes the log package through the handler, at info level
+		slog.SetDefault(slog.New(slog.NewJSONHandler(logOutput, &slog.HandlerOptions{Level: &logLevel})))
+	}
+}
@@ -1,13 +1,29 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
-	"sync"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 var host = ""
@@ -15,58 +31,865 @@ var sslHost = ""
 var port = 80
 var sslPort = 0
 var noHTTP = false
+var listenAddrs stringList
+var retryBind = 0
+var shutdownToken = ""
+var readyFD = 0
+var ticketRotation time.Duration = 0
+var noSessionTickets = false
+var rejectUnknownSNI = false
+var methods = "GET,HEAD"
+var allowedMethods []string
 var useSSL = false
+var acmeOn = false
+var acmeDomains = ""
+var acmeCache = "acme-cache"
+var selfSigned = false
+var keyType = "ecdsa"
+var keyBits = 2048
+var certHosts = "localhost"
+var certOrg = "Gomoose Self-Signed"
+var certDays = 365
 var dir = "."
 var sslCert = "cert.crt"
 var sslKey = "cert.key"
+var useGzip = false
+var useBrotli = false
+var compressLevel = gzip.DefaultCompression
+var index = "index.html"
+var spa = false
+var redirectHTTPS = false
+var redirectCode = http.StatusMovedPermanently
+var authUser = ""
+var authPass = ""
+var authFile = ""
+var logFormat = "common"
+var quiet = false
+var showVersion = false
+var mounts mountList
+var vhosts vhostList
+var stripPrefix = ""
+var basePath = ""
+var downloadExts = ""
+var onlyExts = ""
+var notFoundPage = ""
+var logRanges = false
+var noRanges = false
+var cacheControl = ""
+var cacheHTML time.Duration = 0
+var cacheAssets time.Duration = 0
+var cacheImmutable = `[.-][0-9a-fA-F]{8,}\.[^/]+$`
+var noETag = false
+var configFile = ""
+var shutdownTimeout = 30 * time.Second
+var cors = false
+var corsOrigin = ""
+var corsCredentials = false
+var corsMethods = "GET,HEAD,OPTIONS"
+var corsHeaders = ""
+var corsMaxAge time.Duration = 0
+var healthPath = ""
+var metricsPath = ""
+var rateLimit = 0.0
+var rateBurst = 0
+var trustProxy = false
+var trustedProxies cidrList
+var clientCA = ""
+var clientAuthMode = "verify"
+var tlsMin = "1.2"
+var tlsMax = "1.3"
+var ciphers = ""
+var sniCerts sniList
+var blockDotfiles = false
+var blocked stringList
+var excluded stringList
+var noListing = false
+var prettyListing = false
+var autoindexTemplate = ""
+var rootFile = ""
+var followSymlinks = false
+var allowMissingDir = false
+var secureHeadersOn = false
+var hsts = false
+var hstsMaxAge = 365 * 24 * time.Hour
+var customHeaders headerList
+var serverHeader = ""
+var csp = ""
+var cspReportOnly = false
+var maxHeaderBytes = 0
+var maxBodyBytes int64 = 0
+var maxConns = 0
+var throttle int64 = 0
+var proxyProtocol = false
+var requestIDHeader = "X-Request-ID"
+var proxyProtocolRequire = false
+var readHeaderTimeout = 10 * time.Second
+var readTimeout time.Duration = 0
+var writeTimeout time.Duration = 0
+var idleTimeout = 2 * time.Minute
+var noHTTP2 = false
+var http3On = false
+var webdavOn = false
+var webdavPrefix = "/webdav"
+var webdavReadOnly = false
+var noKeepAlive = false
+var certReload = false
+var ocspStapling = false
+var proxies proxyList
+var proxyKeepPrefix = false
+var dryRunOnly = false
+var verbose = false
+var cgiDir = ""
+var cgiExt = ".cgi"
+var allowUpload = false
+var precompressed = false
+var mimeTypes mimeList
+var jsonListing = false
+var strongETag = false
+var openURL = false
+var livereloadOn = false
+var pushManifest = ""
+var logFile = ""
+var logLevelName = ""
+var logJSON = false
+var logMaxSize int64 = 100
+var logMaxBackups = 5
+var cacheFiles = false
+var cacheMaxSize int64 = 64 << 20
+var cacheMaxFile int64 = 1 << 20
+var allowIPs cidrList
+var denyIPs cidrList
 
 func init() {
 	flag.StringVar(&host, "host", host, "HTTP host to listen on")
+	flag.StringVar(&shutdownToken, "shutdown-token", shutdownToken, "Enables POST /._shutdown from loopback clients sending this in X-Shutdown-Token, to shut down gracefully")
+	flag.IntVar(&readyFD, "ready-fd", readyFD, "File descriptor to write a newline to and close once listening, for service managers (0 to disable)")
+	flag.IntVar(&retryBind, "retry-bind", retryBind, "Times to retry listening on an address that's in use, waiting longer each time (for rolling restarts)")
+	flag.Var(&listenAddrs, "listen", "HTTP address to listen on, as host:port, in place of -host and -port (repeatable)")
 	flag.StringVar(&sslHost, "sslhost", sslHost, "SSL host to listen on")
 	flag.IntVar(&port, "port", port, "HTTP port to listen on")
 	flag.IntVar(&sslPort, "sslport", sslPort, "SSL port to listen on")
+	flag.StringVar(&dir, "dir", dir, "Directory to serve")
+	flag.Var(&mounts, "mount", "Serves a directory under a URL prefix, as prefix=dir (repeatable)")
+	flag.StringVar(&stripPrefix, "strip-prefix", stripPrefix, "URL prefix to serve everything under, like /app when proxied there without the prefix being removed")
+	flag.StringVar(&basePath, "basepath", basePath, "URL prefix to add to HTML pages as a <base> tag, like /app when proxied there, usually alongside -strip-prefix")
+	flag.StringVar(&downloadExts, "download-ext", downloadExts, "Comma-separated file extensions to send as downloads rather than display, e.g. zip,pdf")
+	flag.StringVar(&onlyExts, "only-ext", onlyExts, "Comma-separated file extensions to serve, responding 404 to files with any other, e.g. png,jpg,pdf")
+	flag.Var(&vhosts, "vhost", "Serves a directory for requests to a host, as host=dir, where the host may be a wildcard like *.example.com (repeatable)")
+	flag.Var(&proxies, "proxy", "Proxies requests under a URL prefix to an upstream, as prefix=url (repeatable)")
+	flag.BoolVar(&proxyKeepPrefix, "proxy-keep-prefix", proxyKeepPrefix, "Forwards the full path to -proxy upstreams instead of stripping the prefix")
 	flag.BoolVar(&noHTTP, "nohttp", noHTTP, "Disables HTTP")
 	flag.BoolVar(&useSSL, "ssl", useSSL, "Enables SSL (sets sslport to 443 if unspecified)")
-	flag.StringVar(&sslCert, "cert", sslCert, "File to use as SSL cert")
-	flag.StringVar(&sslKey, "key", sslKey, "File to use as SSL key")
-	flag.Parse()
+	flag.StringVar(&sslCert, "cert", sslCert, "File to use as SSL cert, or - to read the cert and key from stdin as one PEM bundle")
+	flag.StringVar(&sslKey, "key", sslKey, "File to use as SSL key (ignored with -cert -)")
+	flag.BoolVar(&rejectUnknownSNI, "reject-unknown-sni", rejectUnknownSNI, "Fails SSL handshakes for server names no certificate covers, instead of using the default certificate")
+	flag.BoolVar(&noSessionTickets, "no-session-tickets", noSessionTickets, "Disables SSL session tickets, and with them session resumption")
+	flag.DurationVar(&ticketRotation, "ticket-rotation", ticketRotation, "Interval to replace SSL session ticket keys at, keeping the last few valid (0 for Go's default rotation)")
+	flag.BoolVar(&acmeOn, "acme", acmeOn, "Gets and renews certificates for -domains from Let's Encrypt, in place of -cert and -key (implies -ssl)")
+	flag.StringVar(&acmeDomains, "domains", acmeDomains, "Comma-separated domain names -acme gets certificates for")
+	flag.StringVar(&acmeCache, "acme-cache", acmeCache, "Directory -acme keeps certificates and their keys in, which must be outside the served directories")
+	flag.BoolVar(&selfSigned, "self-signed", selfSigned, "Generates a self-signed certificate at startup in place of -cert and -key (implies -ssl)")
+	flag.StringVar(&keyType, "key-type", keyType, "Key type for -self-signed: ecdsa (P-256) or rsa, for older clients")
+	flag.IntVar(&keyBits, "key-bits", keyBits, "RSA key size for -self-signed -key-type rsa")
+	flag.StringVar(&certHosts, "cert-hosts", certHosts, "Comma-separated DNS names and IP addresses the -self-signed certificate is for")
+	flag.StringVar(&certOrg, "cert-org", certOrg, "Organization named in the -self-signed certificate's subject")
+	flag.IntVar(&certDays, "cert-days", certDays, "Days the -self-signed certificate is valid for")
+	flag.BoolVar(&certReload, "cert-reload", certReload, "Reloads the SSL cert and key when they change, without restarting")
+	flag.BoolVar(&ocspStapling, "ocsp", ocspStapling, "Staples OCSP responses from the certificate's issuer to SSL handshakes")
+	flag.StringVar(&tlsMin, "tls-min", tlsMin, "Minimum TLS version: 1.0, 1.1, 1.2 or 1.3")
+	flag.StringVar(&tlsMax, "tls-max", tlsMax, "Maximum TLS version: 1.0, 1.1, 1.2 or 1.3")
+	flag.Var(&sniCerts, "sni", "Certificate for an SSL host name, as host=cert:key (repeatable, host may be *.domain)")
+	flag.StringVar(&ciphers, "ciphers", ciphers, "Comma-separated TLS 1.2 cipher suites to allow (defaults to Go's secure set)")
+	flag.BoolVar(&noHTTP2, "no-http2", noHTTP2, "Disables HTTP/2 over SSL, offering only HTTP/1.1")
+	flag.BoolVar(&webdavOn, "webdav", webdavOn, "Serves -dir over WebDAV at -webdav-prefix, for reading and writing files from a WebDAV client")
+	flag.StringVar(&webdavPrefix, "webdav-prefix", webdavPrefix, "URL path to serve WebDAV at")
+	flag.BoolVar(&webdavReadOnly, "webdav-readonly", webdavReadOnly, "Only allows WebDAV methods that read, not change, files")
+	flag.BoolVar(&http3On, "http3", http3On, "Also serves HTTP/3 over QUIC on the SSL port (UDP), advertised to clients with Alt-Svc")
+	flag.StringVar(&clientCA, "client-ca", clientCA, "CA bundle used to authenticate SSL client certificates")
+	flag.StringVar(&clientAuthMode, "client-auth-mode", clientAuthMode, "Client certificate checking with -client-ca: request, require or verify")
+	flag.BoolVar(&useGzip, "gzip", useGzip, "Enables gzip or deflate compression of responses")
+	flag.BoolVar(&useBrotli, "brotli", useBrotli, "Enables Brotli compression of responses, preferred over gzip when a client accepts both (implies -gzip)")
+	flag.BoolVar(&redirectHTTPS, "redirect-https", redirectHTTPS, "Redirects HTTP requests to HTTPS when SSL is enabled")
+	flag.IntVar(&redirectCode, "redirect-code", redirectCode, "Status code used by -redirect-https (301, 302, 307 or 308)")
+	flag.StringVar(&authUser, "auth-user", authUser, "Username required via HTTP Basic auth")
+	flag.StringVar(&authPass, "auth-pass", authPass, "Password for -auth-user")
+	flag.StringVar(&authFile, "auth-file", authFile, "htpasswd file of users allowed via HTTP Basic auth")
+	flag.StringVar(&logFormat, "log-format", logFormat, "Access log format: common or json")
+	flag.StringVar(&logFile, "log-file", logFile, "File to write logs to instead of stderr")
+	flag.Int64Var(&logMaxSize, "log-max-size", logMaxSize, "Size in megabytes at which -log-file is rotated (0 disables rotation)")
+	flag.IntVar(&logMaxBackups, "log-max-backups", logMaxBackups, "Number of rotated -log-file backups to keep")
+	flag.BoolVar(&logJSON, "log-json", logJSON, "Writes server messages as JSON lines (see -log-format for access logs)")
+	flag.StringVar(&logLevelName, "log-level", logLevelName, "Most verbose messages to log: error, warn, info (including access logs) or debug")
+	flag.BoolVar(&quiet, "quiet", quiet, "Disables access logging and informational messages, like -log-level error")
+	flag.StringVar(&notFoundPage, "404", notFoundPage, "HTML file to serve for missing files")
+	flag.BoolVar(&logRanges, "log-ranges", logRanges, "Includes the requested byte range in access logs for partial responses")
+	flag.BoolVar(&noRanges, "no-ranges", noRanges, "Disables Range requests, always sending full responses")
+	flag.Var(&mimeTypes, "mime", "Content type for a file extension, as .ext=type/subtype (repeatable)")
+	flag.StringVar(&pushManifest, "push", pushManifest, "JSON file mapping pages to assets to preload, and push over HTTP/2")
+	flag.StringVar(&cacheControl, "cache-control", cacheControl, "Cache-Control header value for file responses")
+	flag.DurationVar(&cacheHTML, "cache-html", cacheHTML, "max-age for HTML responses, in place of -cache-control")
+	flag.DurationVar(&cacheAssets, "cache-assets", cacheAssets, "max-age for scripts, styles, images and fonts, in place of -cache-control")
+	flag.StringVar(&cacheImmutable, "cache-immutable", cacheImmutable, "Regular expression for fingerprinted asset paths that -cache-assets also marks immutable (empty to disable)")
+	flag.BoolVar(&cacheFiles, "cache-files", cacheFiles, "Keeps the contents of small files in memory")
+	flag.Int64Var(&cacheMaxSize, "cache-max-size", cacheMaxSize, "Memory budget in bytes for -cache-files, per served directory")
+	flag.Int64Var(&cacheMaxFile, "cache-max-file", cacheMaxFile, "Size in bytes above which files bypass -cache-files")
+	flag.BoolVar(&strongETag, "strong-etag", strongETag, "Sets ETags on files from a SHA-256 hash of their contents")
+	flag.BoolVar(&noETag, "no-etag", noETag, "Strips ETag headers from responses")
+	flag.BoolVar(&cors, "cors", cors, "Enables CORS headers, allowing any origin unless -cors-origin is set")
+	flag.StringVar(&corsOrigin, "cors-origin", corsOrigin, "Comma-separated list of origins allowed by CORS, or * for any (implies -cors)")
+	flag.BoolVar(&corsCredentials, "cors-credentials", corsCredentials, "Allows credentialed CORS requests (requires -cors-origin)")
+	flag.StringVar(&corsMethods, "cors-methods", corsMethods, "Comma-separated methods allowed by CORS preflight responses")
+	flag.StringVar(&corsHeaders, "cors-headers", corsHeaders, "Comma-separated request headers allowed by CORS preflight responses (defaults to those requested)")
+	flag.DurationVar(&corsMaxAge, "cors-max-age", corsMaxAge, "Time browsers may cache CORS preflight responses for (0 for the browser's default)")
+	flag.StringVar(&healthPath, "health-path", healthPath, "Path that answers health checks without touching the filesystem or access log, subject to -allow, -deny, -rate-limit and auth like the files")
+	flag.StringVar(&metricsPath, "metrics-path", metricsPath, "Path that exposes Prometheus metrics, subject to -allow, -deny, -rate-limit and auth like the files")
+	flag.Float64Var(&rateLimit, "rate-limit", rateLimit, "Requests per second allowed from each client IP (0 for unlimited)")
+	flag.IntVar(&rateBurst, "rate-burst", rateBurst, "Requests a client may burst above -rate-limit (defaults to the rate)")
+	flag.BoolVar(&trustProxy, "trust-proxy", trustProxy, "Takes client IPs from the last X-Forwarded-For entry from any peer (prefer -trusted-proxies)")
+	flag.Var(&trustedProxies, "trusted-proxies", "IP or CIDR range of proxies whose X-Forwarded-For or PROXY protocol header is trusted for client IPs (repeatable)")
+	flag.Var(&allowIPs, "allow", "IP or CIDR range allowed to connect, denying all others (repeatable)")
+	flag.Var(&denyIPs, "deny", "IP or CIDR range refused with 403, taking precedence over -allow (repeatable)")
+	flag.BoolVar(&blockDotfiles, "block-dotfiles", blockDotfiles, "Responds 404 to paths containing dotfiles or dot-directories (except .well-known)")
+	flag.Var(&blocked, "block", "Glob pattern of paths to respond 404 to, e.g. *.env or secrets/* (repeatable)")
+	flag.Var(&excluded, "exclude", "Glob pattern of paths to hide from listings and respond 404 to, matched at any depth, e.g. node_modules or build/*.map (repeatable)")
+	flag.BoolVar(&secureHeadersOn, "secure-headers", secureHeadersOn, "Adds nosniff, frame-denying and no-referrer security headers")
+	flag.BoolVar(&hsts, "hsts", hsts, "Adds Strict-Transport-Security to HTTPS responses")
+	flag.DurationVar(&hstsMaxAge, "hsts-max-age", hstsMaxAge, "max-age used by -hsts")
+	flag.StringVar(&serverHeader, "server-header", serverHeader, "Server response header to send, replacing any from proxied backends (empty to remove it)")
+	flag.Var(&customHeaders, "header", "Response header to add, as \"Name: Value\", or \"Name:\" to remove a default (repeatable)")
+	flag.StringVar(&csp, "csp", csp, "Content-Security-Policy for HTML responses")
+	flag.BoolVar(&cspReportOnly, "csp-report-only", cspReportOnly, "Sends -csp as Content-Security-Policy-Report-Only instead")
+	flag.IntVar(&maxHeaderBytes, "max-header-bytes", maxHeaderBytes, "Maximum size of request headers (0 for Go's default of 1MB)")
+	flag.Int64Var(&maxBodyBytes, "max-body-bytes", maxBodyBytes, "Maximum size of request bodies (0 for unlimited)")
+	flag.StringVar(&requestIDHeader, "request-id-header", requestIDHeader, "Header used to pass along or assign each request an ID, which is logged (empty to disable)")
+	flag.BoolVar(&proxyProtocol, "proxy-protocol", proxyProtocol, "Reads the client address from a PROXY protocol (v1 or v2) header sent by a load balancer in -trusted-proxies")
+	flag.BoolVar(&proxyProtocolRequire, "proxy-protocol-require", proxyProtocolRequire, "Rejects connections without a PROXY protocol header (requires -proxy-protocol)")
+	flag.Int64Var(&throttle, "throttle", throttle, "Slows each response body to this many bytes a second, to test slow networks (0 for unlimited)")
+	flag.IntVar(&maxConns, "max-conns", maxConns, "Maximum simultaneous connections across the HTTP and SSL listeners, with new ones waiting for a free slot (0 for unlimited)")
+	flag.DurationVar(&readHeaderTimeout, "read-header-timeout", readHeaderTimeout, "Time allowed to read request headers (0 for no timeout)")
+	flag.DurationVar(&readTimeout, "read-timeout", readTimeout, "Time allowed to read a whole request (0 for no timeout)")
+	flag.DurationVar(&writeTimeout, "write-timeout", writeTimeout, "Time allowed to write a response (0 for no timeout, best for large downloads)")
+	flag.DurationVar(&idleTimeout, "idle-timeout", idleTimeout, "Time to keep idle keep-alive connections open (0 for no timeout)")
+	flag.BoolVar(&noKeepAlive, "no-keepalive", noKeepAlive, "Disables keep-alive, closing each connection after one response")
+	flag.StringVar(&cgiDir, "cgi-dir", cgiDir, "Directory within -dir whose scripts are run as CGI instead of served, e.g. cgi-bin")
+	flag.StringVar(&cgiExt, "cgi-ext", cgiExt, "File extension of scripts run from -cgi-dir")
+	flag.StringVar(&methods, "methods", methods, "Comma-separated request methods allowed for served files, others getting a 405 (-allow-upload adds PUT to the default)")
+	flag.BoolVar(&allowUpload, "allow-upload", allowUpload, "Writes the bodies of PUT requests to the served directories (use with auth)")
+	flag.StringVar(&index, "index", index, "Comma-separated list of index files to try for directories")
+	flag.BoolVar(&noListing, "no-listing", noListing, "Responds 403 to directories without an index file instead of listing them")
+	flag.BoolVar(&prettyListing, "pretty-listing", prettyListing, "Renders styled, sortable directory listings")
+	flag.BoolVar(&allowMissingDir, "allow-missing-dir", allowMissingDir, "Starts even if a served directory doesn't exist yet, instead of exiting")
+	flag.BoolVar(&followSymlinks, "follow-symlinks", followSymlinks, "Serves files through symlinks that point outside the served directory, which are refused by default")
+	flag.StringVar(&rootFile, "root-file", rootFile, "File in the served directory to serve for requests for / (instead of the index file)")
+	flag.StringVar(&autoindexTemplate, "autoindex-template", autoindexTemplate, "HTML template file used to render directory listings in place of the built-in one (implies -pretty-listing)")
+	flag.BoolVar(&jsonListing, "json-listing", jsonListing, "Lists directories as JSON for requests with ?format=json")
+	flag.BoolVar(&spa, "spa", spa, "Serves the index file for navigations to missing paths (single-page apps)")
+	flag.BoolVar(&precompressed, "precompressed", precompressed, "Serves file.br or file.gz in place of file to clients accepting Brotli or gzip")
+	flag.IntVar(&compressLevel, "compress-level", compressLevel, "Compression level for -gzip and -brotli, 1 (fastest) to 9 (smallest), -1 for default")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", shutdownTimeout, "Time to wait for requests to finish when shutting down (0 waits indefinitely)")
+	flag.StringVar(&configFile, "config", configFile, "JSON or YAML file of flag values, overridden by command-line flags")
+	flag.BoolVar(&livereloadOn, "livereload", livereloadOn, "Reloads open pages when served files change (for development)")
+	flag.BoolVar(&openURL, "open", openURL, "Opens the served site in the default browser once listening")
+	flag.BoolVar(&verbose, "verbose", verbose, "Logs every setting at startup, not just those changed from the defaults")
+	flag.BoolVar(&dryRunOnly, "dry-run", dryRunOnly, "Checks the configuration, directories and certificates, then exits without serving")
+	flag.BoolVar(&showVersion, "version", showVersion, "Prints version information and exits")
 }
 
 func main() {
+	// parsed here rather than in init, so tests can run with their own flags
+	flag.Parse()
+	if showVersion {
+		fmt.Println(versionString())
+		return
+	}
+	if err := loadEnv(); err != nil {
+		log.Fatal("Unable to load environment: ", err)
+	}
+	if configFile != "" {
+		if err := loadConfigFile(configFile); err != nil {
+			log.Fatal("Unable to load config: ", err)
+		}
+	}
+
+	if logFile != "" {
+		f, err := openRotatingFile(logFile, logMaxSize<<20, logMaxBackups)
+		if err != nil {
+			log.Fatal("Unable to open log file: ", err)
+		}
+		log.SetOutput(f)
+	}
+
+	level, err := resolveLogLevel(logLevelName, quiet, verbose)
+	if err != nil {
+		log.Fatal(err)
+	}
+	setupLogging(logJSON)
+	setLogLevel(level)
+
+	if acmeOn || selfSigned {
+		useSSL = true
+	}
 	if sslPort <= 0 && useSSL {
 		sslPort = 443
 	}
 	useSSL = sslPort > 0
+	if http3On && !useSSL {
+		log.Fatal("-http3 requires SSL")
+	}
+	certificate, err := envCertificate()
+	if err != nil {
+		log.Fatal(err)
+	}
+	certSource := "$" + certPEMEnv
+	if sslCert == "-" || sslKey == "-" {
+		if certificate != nil {
+			log.Fatal("-cert - can't be used with ", certPEMEnv)
+		}
+		if certificate, err = stdinCertificate(os.Stdin); err != nil {
+			log.Fatal(err)
+		}
+		certSource = "stdin"
+	}
+	if certificate != nil && (certReload || ocspStapling) {
+		log.Fatal("-cert-reload and -ocsp need -cert and -key files, not ", certSource)
+	}
+	if acmeOn && (certificate != nil || certReload || ocspStapling) {
+		log.Fatal("-acme can't be used with ", certSource, ", -cert-reload or -ocsp")
+	}
+	if selfSigned {
+		if acmeOn || certificate != nil || certReload || ocspStapling {
+			log.Fatal("-self-signed can't be used with -acme, ", certPEMEnv, ", -cert -, -cert-reload or -ocsp")
+		}
+		certPEM, keyPEM, err := generateSelfSignedCert(parseList(certHosts), certOrg, certDays, keyType, keyBits)
+		if err != nil {
+			log.Fatal("Unable to generate a self-signed certificate: ", err)
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			log.Fatal(err)
+		}
+		certificate, certSource = &cert, "self-signed"
+	}
+
+	if logFormat != "common" && logFormat != "json" {
+		log.Fatal("Invalid log format: ", logFormat)
+	}
+	if compressLevel < gzip.HuffmanOnly || compressLevel > gzip.BestCompression {
+		log.Fatal("Invalid compression level: ", compressLevel)
+	}
+
+	for name, d := range map[string]time.Duration{
+		"read-header-timeout": readHeaderTimeout, "read-timeout": readTimeout,
+		"write-timeout": writeTimeout, "idle-timeout": idleTimeout, "ticket-rotation": ticketRotation,
+	} {
+		if d < 0 {
+			log.Fatalf("Invalid -%s: %v", name, d)
+		}
+	}
+	slog.Info("Configuration", "flags", effectiveConfig(verbose))
+
+	if proxyProtocolRequire && !proxyProtocol {
+		log.Fatal("-proxy-protocol-require requires -proxy-protocol")
+	}
+	if proxyProtocol && len(trustedProxies) == 0 {
+		log.Fatal("-proxy-protocol requires -trusted-proxies to list the load balancers that may send PROXY headers")
+	}
+	if noSessionTickets && ticketRotation > 0 {
+		log.Fatal("-ticket-rotation has no effect with -no-session-tickets")
+	}
+	if retryBind < 0 {
+		log.Fatal("Invalid -retry-bind: ", retryBind)
+	}
+	if throttle < 0 {
+		log.Fatal("Invalid -throttle: ", throttle)
+	}
+	if maxConns < 0 {
+		log.Fatal("Invalid -max-conns: ", maxConns)
+	}
+	if noListing && jsonListing {
+		log.Fatal("-json-listing can't be used with -no-listing, which it would bypass")
+	}
+	if strongETag && noETag {
+		log.Fatal("-strong-etag and -no-etag can't be used together")
+	}
+	if autoindexTemplate != "" {
+		t, err := loadListingTemplate(autoindexTemplate)
+		if err != nil {
+			log.Fatal("Invalid -autoindex-template: ", err)
+		}
+		listingTemplate = t
+		prettyListing = true
+	}
+	if err := registerMimeTypes(mimeTypes); err != nil {
+		log.Fatal("Unable to register MIME types: ", err)
+	}
 
 	path, err := filepath.Abs(dir)
 	if err != nil {
 		log.Fatal("Unable to resolve directory:", dir, err)
 	}
-	var wg sync.WaitGroup
-	log.Println("Serving", path)
-	handler := http.FileServer(http.Dir(path))
-	if !noHTTP {
-		log.Println("HTTP listening on port", port)
-		wg.Add(1)
-		go func() {
-			err := http.ListenAndServe(host+":"+strconv.Itoa(port), handler)
-			if err != nil {
-				log.Println("HTTP listening error:", err)
+	if !allowMissingDir && !dryRunOnly {
+		for _, d := range servedDirs(path) {
+			if err := checkDir(d); err != nil {
+				log.Fatalf("Unable to serve directory: %v (use -allow-missing-dir if it's created later)", err)
 			}
-			wg.Done()
-		}()
-	}
-	if useSSL {
-		log.Printf("SSL listening on port %d (cert: %s, key: %s)", sslPort, sslCert, sslKey)
-		go func() {
-			err := http.ListenAndServeTLS(sslHost+":"+strconv.Itoa(sslPort), sslCert, sslKey, handler)
-			wg.Add(1)
-			if err != nil {
-				log.Println("SSL listening error:", err)
+		}
+	}
+	slog.Info("Serving", "dir", path)
+	srv, err := NewServer(&Config{
+		Dir:         path,
+		Host:        host,
+		Port:        port,
+		Listen:      listenAddrs,
+		NoHTTP:      noHTTP,
+		SSL:         useSSL,
+		SSLHost:     sslHost,
+		SSLPort:     sslPort,
+		SSLCert:     sslCert,
+		SSLKey:      sslKey,
+		Certificate: certificate,
+		CertSource:  certSource,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if dryRunOnly {
+		if err := dryRun(srv, servedDirs(path)); err != nil {
+			log.Fatal("Dry run failed: ", err)
+		}
+		return
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if configFile == "" {
+				slog.Warn("Received SIGHUP, but there is no -config to reload")
+				continue
+			}
+			if err := reloadConfig(configFile); err != nil {
+				slog.Error("Unable to reload config, keeping the current settings", "err", err)
+				continue
 			}
-			wg.Done()
-		}()
+			slog.Info("Reloaded config", "file", configFile)
+		}
+	}()
+	if err := srv.Start(ctx); err != nil {
+		log.Fatal("Unable to start: ", err)
+	}
+	if readyFD > 0 {
+		if err := notifyReady(os.NewFile(uintptr(readyFD), "ready-fd")); err != nil {
+			slog.Warn("Unable to signal readiness", "fd", readyFD, "err", err)
+		}
+	}
+	if openURL {
+		url := ""
+		if addr := srv.HTTPAddr(); addr != nil {
+			url = serverURL("http", addr)
+		} else if addr := srv.HTTPSAddr(); addr != nil {
+			url = serverURL("https", addr)
+		}
+		if err := openBrowser(url); err != nil {
+			slog.Warn("Unable to open browser", "url", url, "err", err)
+		}
+	}
+	if err := srv.Wait(); err != nil {
+		log.Fatal("Exiting with errors: ", err)
 	}
-	wg.Wait()
 	fmt.Println("Done - exiting")
 }
+
+// BuildHandler builds the handler a server for config would use, with every layer
+// the flags configure, without starting any servers, so it can be mounted in another
+// program's mux. Without a Server, -health-path doesn't count connections, and
+// -shutdown-token and -acme have no effect
+func BuildHandler(config *Config) (http.Handler, error) {
+	handler, _, err := buildHandler(config, nil)
+	return handler, err
+}
+
+// buildHandler builds the handler serving config.Dir (or config.FS), shared by the HTTP and SSL
+// servers, from the served files through protections, compression and headers out to
+// the redirects, access control, logging and endpoints. srv, if not nil, is the server
+// it's for. It also returns the livereload event source, if -livereload is set
+func buildHandler(config *Config, srv *Server) (handler http.Handler, lr *livereload, err error) {
+	root := config.Dir
+	var protected []string
+	if config.SSL {
+		// never serve the SSL keys, wherever they sit in the served directories
+		key := config.SSLKey
+		if config.Certificate != nil || (srv != nil && srv.acme != nil) {
+			key = ""
+		}
+		if protected, err = keyFiles(key); err != nil {
+			return nil, nil, err
+		}
+	}
+	allowedMethods = nil
+	for _, method := range parseList(methods) {
+		allowedMethods = append(allowedMethods, strings.ToUpper(method))
+	}
+	if len(allowedMethods) == 0 {
+		return nil, nil, errors.New("-methods can't be empty")
+	}
+	if allowUpload && methods == flag.Lookup("methods").DefValue {
+		allowedMethods = append(allowedMethods, http.MethodPut)
+	}
+	if config.FS != nil {
+		for _, option := range []struct {
+			name string
+			set  bool
+		}{
+			{"-allow-upload", allowUpload}, {"-root-file", rootFile != ""}, {"-cgi-dir", cgiDir != ""},
+			{"-push-manifest", pushManifest != ""}, {"-livereload", livereloadOn}, {"-webdav", webdavOn},
+		} {
+			if option.set {
+				return nil, nil, fmt.Errorf("%s needs a directory to serve, not an fs.FS", option.name)
+			}
+		}
+		handler = fsHandler(config.FS)
+	} else {
+		handler = dirHandler(root, "", protected)
+	}
+	if rootFile != "" {
+		fsys, err := openRootFile(root, rootFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid -root-file: %w", err)
+		}
+		handler = rootFileHandler(handler, fsys, rootFile)
+	}
+	if cgiDir != "" {
+		if cgiExt == "" {
+			return nil, nil, errors.New("-cgi-ext can't be empty")
+		}
+		slog.Info("Running CGI scripts", "dir", filepath.Join(root, cgiDir), "ext", cgiExt)
+		handler = cgiHandler(handler, root, filepath.ToSlash(cgiDir), cgiExt, followSymlinks)
+	}
+	if len(mounts) > 0 {
+		mux := http.NewServeMux()
+		for _, m := range mounts {
+			slog.Info("Serving", "dir", m.dir, "prefix", m.prefix)
+			prefix := strings.TrimSuffix(m.prefix, "/")
+			mux.Handle(m.prefix, http.StripPrefix(prefix, dirHandler(m.dir, prefix, protected)))
+		}
+		mux.Handle("/", handler)
+		handler = mux
+	}
+	if len(vhosts) > 0 {
+		hosts := map[string]http.Handler{}
+		for _, v := range vhosts {
+			if _, ok := hosts[v.host]; ok {
+				return nil, nil, fmt.Errorf("duplicate -vhost %s", v.host)
+			}
+			slog.Info("Serving", "dir", v.dir, "host", v.host)
+			hosts[v.host] = dirHandler(v.dir, "", protected)
+		}
+		handler = vhostHandler(handler, hosts)
+	}
+	if err := validatePatterns(blocked); err != nil {
+		return nil, nil, fmt.Errorf("unable to parse -block: %w", err)
+	}
+	if err := validatePatterns(excluded); err != nil {
+		return nil, nil, fmt.Errorf("unable to parse -exclude: %w", err)
+	}
+	handler = reloadable(handler, func(h http.Handler) http.Handler {
+		hidden := hiddenPaths()
+		currentHidden.Store(&hidden)
+		if blockDotfiles || len(blocked) > 0 || len(excluded) > 0 {
+			return protectedFileHandler(h, hidden)
+		}
+		return h
+	})
+	if noRanges {
+		handler = noRangesHandler(handler)
+	}
+	if cacheControl != "" || cacheHTML > 0 || cacheAssets > 0 || noETag {
+		policy := &cachePolicy{fallback: cacheControl, html: cacheHTML, assets: cacheAssets}
+		if cacheImmutable != "" {
+			re, err := regexp.Compile(cacheImmutable)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid -cache-immutable: %w", err)
+			}
+			policy.immutable = re
+		}
+		handler = cacheHandler(handler, policy, noETag)
+	}
+	if exts := parseList(downloadExts); len(exts) > 0 {
+		handler = downloadHandler(handler, exts)
+	}
+	if notFoundPage != "" {
+		page, err := os.ReadFile(notFoundPage)
+		if err != nil {
+			slog.Warn("Unable to read 404 page, using the default", "err", err)
+		} else {
+			handler = notFoundHandler(handler, page)
+		}
+	}
+	if pushManifest != "" {
+		manifest, err := loadPushManifest(pushManifest, root)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to load push manifest: %w", err)
+		}
+		handler = pushHandler(handler, manifest)
+	}
+	if prefix := strings.Trim(basePath, "/"); prefix != "" {
+		handler = basePathHandler(handler, "/"+prefix)
+	}
+	if livereloadOn {
+		lr = newLivereload(servedDirs(root))
+		// pages reach the server under -basepath through a proxy, or -strip-prefix directly
+		prefix := strings.Trim(basePath, "/")
+		if prefix == "" {
+			prefix = strings.Trim(stripPrefix, "/")
+		}
+		if prefix != "" {
+			prefix = "/" + prefix
+		}
+		handler = livereloadHandler(handler, prefix)
+	}
+	if useGzip || useBrotli {
+		handler = gzipHandler(handler, useBrotli)
+	}
+	if lr != nil {
+		handler = livereloadEndpointHandler(handler, lr)
+	}
+	if len(proxies) > 0 {
+		handler = proxyRoutesHandler(handler, proxies, proxyKeepPrefix)
+	}
+	if webdavOn {
+		if handler, err = webdavHandler(handler, root, webdavPrefix, webdavReadOnly, protected); err != nil {
+			return nil, nil, fmt.Errorf("unable to serve WebDAV: %w", err)
+		}
+	}
+	if allowUpload && authUser == "" && authFile == "" {
+		slog.Warn("-allow-upload lets anyone write files without -auth-user or -auth-file")
+	}
+	if webdavOn && !webdavReadOnly && authUser == "" && authFile == "" {
+		slog.Warn("-webdav lets anyone change files without -auth-user or -auth-file")
+	}
+	var users map[string]string
+	if authUser != "" || authFile != "" {
+		if users, err = loadUsers(authUser, authPass, authFile); err != nil {
+			return nil, nil, fmt.Errorf("unable to load users: %w", err)
+		}
+		handler = basicAuthHandler(handler, users)
+	}
+	if cors || corsOrigin != "" || corsCredentials {
+		policy := corsPolicy{
+			origins:     parseList(corsOrigin),
+			credentials: corsCredentials,
+			methods:     parseList(corsMethods),
+			headers:     parseList(corsHeaders),
+			maxAge:      corsMaxAge,
+		}
+		if corsCredentials && (len(policy.origins) == 0 || slices.Contains(policy.origins, "*")) {
+			return nil, nil, errors.New("-cors-credentials needs -cors-origin to list specific origins, since browsers refuse credentials for any origin")
+		}
+		handler = corsHandler(handler, policy)
+	}
+	handler = reloadable(handler, func(h http.Handler) http.Handler {
+		if csp != "" {
+			h = cspHandler(h, csp, cspReportOnly)
+		}
+		return headersHandler(h, responseHeaders(secureHeadersOn, customHeaders), serverHeader)
+	})
+	if prefix := strings.Trim(stripPrefix, "/"); prefix != "" {
+		handler = stripPrefixHandler(handler, "/"+prefix)
+	}
+	if hsts {
+		handler = hstsHandler(handler, hstsMaxAge)
+	}
+	if redirectHTTPS {
+		switch {
+		case !config.SSL:
+			slog.Warn("-redirect-https has no effect without SSL enabled")
+		case redirectCode != http.StatusMovedPermanently && redirectCode != http.StatusFound &&
+			redirectCode != http.StatusTemporaryRedirect && redirectCode != http.StatusPermanentRedirect:
+			return nil, nil, fmt.Errorf("invalid redirect code %d", redirectCode)
+		default:
+			handler = httpsRedirectHandler(handler, config.SSLPort, redirectCode)
+		}
+	}
+	var limiter *rateLimiter
+	if rateLimit > 0 {
+		if rateBurst <= 0 {
+			rateBurst = int(math.Max(1, math.Ceil(rateLimit)))
+		}
+		limiter = newRateLimiter(rateLimit, rateBurst)
+	}
+	// guard puts h behind the same IP filtering and rate limiting as the files, and
+	// with auth, the same users. The endpoints are answered ahead of the logging and
+	// metrics, so they're guarded separately
+	guard := func(h http.Handler, auth bool) http.Handler {
+		if auth && users != nil {
+			h = basicAuthHandler(h, users)
+		}
+		if limiter != nil {
+			h = rateLimitHandler(h, limiter)
+		}
+		if len(allowIPs) > 0 || len(denyIPs) > 0 {
+			h = ipFilterHandler(h, allowIPs, denyIPs)
+		}
+		return h
+	}
+	handler = guard(handler, false)
+	if maxBodyBytes > 0 {
+		handler = maxBodyHandler(handler, maxBodyBytes)
+	}
+	if throttle > 0 {
+		handler = throttleHandler(handler, throttle)
+	}
+	handler = reloadable(handler, func(h http.Handler) http.Handler {
+		if !logEnabled(slog.LevelInfo) {
+			return h
+		}
+		return accessLogHandler(h, logFormat, logRanges)
+	})
+	if requestIDHeader != "" {
+		handler = requestIDHandler(handler, requestIDHeader)
+	}
+	if metricsPath != "" {
+		m := newMetrics()
+		handler = endpointHandler(metricsHandler(handler, m), metricsPath, guard(m, true))
+	}
+	if shutdownToken != "" && srv != nil {
+		handler = endpointHandler(handler, shutdownPath, guard(shutdownHandler(shutdownToken, srv.quit), false))
+	}
+	if healthPath != "" {
+		var connections func() int64
+		if srv != nil {
+			connections = srv.ActiveConnections
+		}
+		handler = endpointHandler(handler, healthPath, guard(healthHandler(connections), true))
+	}
+	if srv != nil && srv.acme != nil {
+		// challenges are answered ahead of any redirect, filtering or auth. They only
+		// come over HTTP, and their tokens are public, so they're left to SSL too
+		handler = srv.acme.HTTPHandler(handler)
+	}
+	return handler, lr, nil
+}
+
+// newHTTPServer creates a server for addr with the configured timeouts and limits
+func newHTTPServer(addr string, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+		MaxHeaderBytes:    maxHeaderBytes,
+	}
+	if noKeepAlive {
+		// responses then carry Connection: close
+		srv.SetKeepAlivesEnabled(false)
+	}
+	return srv
+}
+
+// listenAddr joins host and port into an address to listen on, bracketing IPv6 hosts
+// (which may also be given already bracketed, like [::1])
+func listenAddr(host string, port int) string {
+	return net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(port))
+}
+
+// servedDirs lists root along with the -mount and -vhost directories
+func servedDirs(root string) []string {
+	dirs := []string{root}
+	for _, m := range mounts {
+		dirs = append(dirs, m.dir)
+	}
+	for _, v := range vhosts {
+		dirs = append(dirs, v.dir)
+	}
+	return dirs
+}
+
+// dirHandler serves the directory root at the URL path prefix, hiding the protected files and those without
+// an -only-ext extension, accepting uploads with -allow-upload and refusing methods
+// not in -methods
+func dirHandler(root, prefix string, protected []string) http.Handler {
+	fsys := newSafeFS(root, followSymlinks)
+	handler := fileHandler(fsys, prefix)
+	if allowUpload {
+		handler = uploadHandler(handler, root)
+	}
+	if len(protected) > 0 {
+		handler = blockedFileHandler(handler, root, protected)
+	}
+	if exts := parseList(onlyExts); len(exts) > 0 {
+		handler = extensionHandler(handler, fsys, exts, spa)
+	}
+	return methodsHandler(handler, allowedMethods)
+}
+
+// fsHandler serves fsys, like an embed.FS, hiding files without an -only-ext extension
+// and refusing methods not in -methods
+func fsHandler(fsys fs.FS) http.Handler {
+	handler := fileHandler(fsys, "")
+	if exts := parseList(onlyExts); len(exts) > 0 {
+		handler = extensionHandler(handler, fsys, exts, spa)
+	}
+	return methodsHandler(handler, allowedMethods)
+}
+
+// hiddenPaths returns whether a clean URL path is hidden by the current -block-dotfiles,
+// -block and -exclude settings
+func hiddenPaths() func(string) bool {
+	dotfiles, blocks, excludes := blockDotfiles, blocked, excluded
+	return func(p string) bool {
+		return (dotfiles && hasDotfile(p)) || matchesBlocked(p, blocks) || matchesExcluded(p, excludes)
+	}
+}
+
+// currentHidden holds the latest hiddenPaths, replaced whenever the layer responding
+// 404 to them is rebuilt, so listings hide the same paths after a reload
+var currentHidden atomic.Pointer[func(string) bool]
+
+// isHidden reports whether the clean URL path p is hidden by the current settings
+func isHidden(p string) bool {
+	if hidden := currentHidden.Load(); hidden != nil {
+		return (*hidden)(p)
+	}
+	return false
+}
+
+// fileHandler serves fsys (a directory or e.g. an embed.FS) at the URL path prefix, with
+// the configured index and fallback behavior
+func fileHandler(fsys fs.FS, prefix string) http.Handler {
+	httpFS := http.FS(fsys)
+	// listings leave out anything -block-dotfiles, -block or -exclude would hide, which
+	// are matched against the full URL path as they are for requests
+	hide := func(p string) bool {
+		return isHidden(path.Join("/", prefix, p))
+	}
+	var handler http.Handler = http.FileServer(http.FS(hiddenFS{fsys, hide}))
+	if cacheFiles {
+		handler = fileCacheHandler(handler, fsys, newFileCache(cacheMaxSize), min(cacheMaxFile, cacheMaxSize))
+	}
+	if strongETag {
+		handler = strongETagHandler(handler, fsys)
+	}
+	if precompressed {
+		handler = precompressedHandler(handler, fsys)
+	}
+	if index != "index.html" {
+		handler = indexHandler(handler, httpFS, parseList(index))
+	}
+	if prettyListing {
+		handler = prettyListingHandler(handler, fsys, parseList(index), hide)
+	}
+	if jsonListing {
+		handler = jsonListingHandler(handler, fsys, hide)
+	}
+	if noListing {
+		handler = noListingHandler(handler, httpFS, parseList(index))
+	}
+	if spa {
+		handler = spaHandler(handler, httpFS, parseList(index))
+	}
+	return handler
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenAddr(t *testing.T) {
+	for _, tc := range []struct {
+		host string
+		port int
+		want string
+	}{
+		{"", 8080, ":8080"},
+		{"127.0.0.1", 80, "127.0.0.1:80"},
+		{"::1", 8080, "[::1]:8080"},
+		{"[::1]", 8080, "[::1]:8080"},
+		{"fe80::1%eth0", 443, "[fe80::1%eth0]:443"},
+		{"example.com", 443, "example.com:443"},
+	} {
+		if got := listenAddr(tc.host, tc.port); got != tc.want {
+			t.Errorf("listenAddr(%q, %d) = %q, want %q", tc.host, tc.port, got, tc.want)
+		}
+	}
+}
+
+func TestServeIPv6(t *testing.T) {
+	ln, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skip("IPv6 loopback unavailable: ", err)
+	}
+	ln.Close()
+
+	srv := &Server{httpServer: newHTTPServer(listenAddr("::1", 0), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := srv.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		srv.Wait()
+	})
+
+	addr := srv.HTTPAddr().(*net.TCPAddr)
+	if !addr.IP.Equal(net.IPv6loopback) {
+		t.Fatalf("listening on %s, want ::1", addr)
+	}
+	resp, err := http.Get("http://" + addr.String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "ok" {
+		t.Errorf("got body %q, want %q", body, "ok")
+	}
+}
+
+func TestNoKeepAlive(t *testing.T) {
+	old := noKeepAlive
+	t.Cleanup(func() { noKeepAlive = old })
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	for _, disabled := range []bool{false, true} {
+		noKeepAlive = disabled
+		ts := httptest.NewUnstartedServer(handler)
+		ts.Config = newHTTPServer("", handler)
+		ts.Start()
+		resp, err := ts.Client().Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		ts.Close()
+		if resp.Close != disabled {
+			t.Errorf("-no-keepalive=%t: got Connection %q", disabled, resp.Header.Get("Connection"))
+		}
+	}
+}
+
+func TestBuildHandler(t *testing.T) {
+	root := writeTestFiles(t, map[string]string{"page.txt": "hello", "cert.key": "secret"})
+	oldHSTS, oldRedirect, oldHealth := hsts, redirectHTTPS, healthPath
+	t.Cleanup(func() { hsts, redirectHTTPS, healthPath = oldHSTS, oldRedirect, oldHealth })
+	hsts, redirectHTTPS, healthPath = true, true, "/healthz"
+
+	handler, err := BuildHandler(&Config{Dir: root, SSL: true, SSLPort: 8443, SSLKey: filepath.Join(root, "cert.key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(p string, secure bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com"+p, nil)
+		if secure {
+			req.TLS = &tls.ConnectionState{}
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// one handler serves both: plain HTTP is redirected, and only SSL gets HSTS
+	rec := get("/page.txt", false)
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "https://example.com:8443/page.txt" {
+		t.Errorf("HTTP: got %d to %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec.Header().Get("Strict-Transport-Security") != "" {
+		t.Error("HTTP: got an HSTS header")
+	}
+	rec = get("/page.txt", true)
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+		t.Errorf("SSL: got %d %q", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("Strict-Transport-Security") == "" {
+		t.Error("SSL: no HSTS header")
+	}
+	if rec := get("/cert.key", true); rec.Code != http.StatusNotFound {
+		t.Errorf("SSL key: got %d", rec.Code)
+	}
+	// without a server there are no connections to count
+	if rec := get("/healthz", true); rec.Body.String() != "{\"status\":\"ok\"}\n" {
+		t.Errorf("health: got %q", rec.Body)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// upper bounds of the request duration histogram, in seconds
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metrics accumulates request statistics in the Prometheus data model
+type metrics struct {
+	mu       sync.Mutex
+	requests map[int]uint64
+	bytes    uint64
+	buckets  []uint64
+	count    uint64
+	sum      float64
+}
+
+func newMetrics() *metrics {
+	return &metrics{requests: map[int]uint64{}, buckets: make([]uint64, len(durationBuckets))}
+}
+
+func (m *metrics) observe(status int, size int64, d time.Duration) {
+	seconds := d.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[status]++
+	m.bytes += uint64(size)
+	m.count++
+	m.sum += seconds
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			m.buckets[i]++
+		}
+	}
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	fmt.Fprintln(w, "# HELP gomoose_http_requests_total Total HTTP requests by status code.")
+	fmt.Fprintln(w, "# TYPE gomoose_http_requests_total counter")
+	codes := make([]int, 0, len(m.requests))
+	for code := range m.requests {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "gomoose_http_requests_total{code=\"%d\"} %d\n", code, m.requests[code])
+	}
+
+	fmt.Fprintln(w, "# HELP gomoose_http_request_duration_seconds Time taken to serve HTTP requests.")
+	fmt.Fprintln(w, "# TYPE gomoose_http_request_duration_seconds histogram")
+	for i, bound := range durationBuckets {
+		le := strconv.FormatFloat(bound, 'g', -1, 64)
+		fmt.Fprintf(w, "gomoose_http_request_duration_seconds_bucket{le=\"%s\"} %d\n", le, m.buckets[i])
+	}
+	fmt.Fprintf(w, "gomoose_http_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.count)
+	fmt.Fprintf(w, "gomoose_http_request_duration_seconds_sum %g\n", m.sum)
+	fmt.Fprintf(w, "gomoose_http_request_duration_seconds_count %d\n", m.count)
+
+	fmt.Fprintln(w, "# HELP gomoose_http_response_bytes_total Total bytes of response bodies served.")
+	fmt.Fprintln(w, "# TYPE gomoose_http_response_bytes_total counter")
+	fmt.Fprintf(w, "gomoose_http_response_bytes_total %d\n", m.bytes)
+}
+
+// metricsHandler records every request served by h in m
+func metricsHandler(h http.Handler, m *metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w}
+		h.ServeHTTP(rw, r)
+		status := rw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		m.observe(status, rw.size, time.Since(start))
+	})
+}
+
+// endpointHandler answers requests for exactly path with endpoint, like the metrics
+// or health checks, passing the rest on to h
+func endpointHandler(h http.Handler, path string, endpoint http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			h.ServeHTTP(w, r)
+			return
+		}
+		endpoint.ServeHTTP(w, r)
+	})
+}
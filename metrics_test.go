@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEndpointAccessControl(t *testing.T) {
+	root := writeTestFiles(t, map[string]string{"page.txt": "hello"})
+	oldMetrics, oldHealth, oldDeny := metricsPath, healthPath, denyIPs
+	oldUser, oldPass, oldRate, oldBurst := authUser, authPass, rateLimit, rateBurst
+	t.Cleanup(func() {
+		metricsPath, healthPath, denyIPs = oldMetrics, oldHealth, oldDeny
+		authUser, authPass, rateLimit, rateBurst = oldUser, oldPass, oldRate, oldBurst
+	})
+	_, denied, _ := net.ParseCIDR("192.0.2.0/24")
+	metricsPath, healthPath, denyIPs = "/metrics", "/healthz", cidrList{denied}
+	authUser, authPass, rateLimit, rateBurst = "alice", "secret", 1, 3
+
+	handler, err := BuildHandler(&Config{Dir: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(p, remote string, auth bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, p, nil)
+		req.RemoteAddr = remote
+		if auth {
+			req.SetBasicAuth("alice", "secret")
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, p := range []string{"/metrics", "/healthz", "/page.txt"} {
+		if rec := get(p, "192.0.2.1:1000", true); rec.Code != http.StatusForbidden {
+			t.Errorf("denied IP, GET %s: got %d, want %d", p, rec.Code, http.StatusForbidden)
+		}
+		if rec := get(p, "198.51.100.1:1000", false); rec.Code != http.StatusUnauthorized {
+			t.Errorf("no credentials, GET %s: got %d, want %d", p, rec.Code, http.StatusUnauthorized)
+		}
+	}
+	rec := get("/metrics", "198.51.100.2:1000", true)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "gomoose_http_requests_total") {
+		t.Errorf("GET /metrics: got %d %q", rec.Code, rec.Body)
+	}
+	if rec := get("/healthz", "198.51.100.2:1000", true); rec.Code != http.StatusOK {
+		t.Errorf("GET /healthz: got %d", rec.Code)
+	}
+	if rec := get("/page.txt", "198.51.100.2:1000", true); rec.Code != http.StatusOK {
+		t.Errorf("GET /page.txt: got %d", rec.Code)
+	}
+	// the endpoints drew on the same rate limit as the files
+	if rec := get("/page.txt", "198.51.100.2:1000", true); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("past the burst: got %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// defaultMimeTypes fill gaps in the system MIME tables that break browsers when missing
+var defaultMimeTypes = map[string]string{
+	".wasm": "application/wasm",
+	".mjs":  "text/javascript; charset=utf-8",
+}
+
+// mimeList collects repeated -mime .ext=type flags
+type mimeList [][2]string
+
+func (l *mimeList) String() string {
+	var parts []string
+	for _, m := range *l {
+		parts = append(parts, m[0]+"="+m[1])
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *mimeList) Set(value string) error {
+	ext, typ, ok := strings.Cut(value, "=")
+	ext, typ = strings.TrimSpace(ext), strings.TrimSpace(typ)
+	if !ok || ext == "" || typ == "" {
+		return fmt.Errorf("expected .ext=type/subtype, got %q", value)
+	}
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	if _, _, err := mime.ParseMediaType(typ); err != nil || !strings.Contains(typ, "/") {
+		return fmt.Errorf("invalid MIME type %q", typ)
+	}
+	*l = append(*l, [2]string{ext, typ})
+	return nil
+}
+
+// registerMimeTypes adds the default types, then the overrides, to the table used
+// by the file server. It must run before any request is served
+func registerMimeTypes(overrides mimeList) error {
+	for ext, typ := range defaultMimeTypes {
+		if err := mime.AddExtensionType(ext, typ); err != nil {
+			return err
+		}
+	}
+	for _, m := range overrides {
+		if err := mime.AddExtensionType(m[0], m[1]); err != nil {
+			return fmt.Errorf("%s: %w", m[0], err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+type mount struct {
+	prefix string
+	dir    string
+}
+
+// mountList collects repeated -mount prefix=dir flags
+type mountList []mount
+
+func (m *mountList) String() string {
+	var parts []string
+	for _, mt := range *m {
+		parts = append(parts, mt.prefix+"="+mt.dir)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (m *mountList) Set(value string) error {
+	prefix, dir, ok := strings.Cut(value, "=")
+	if !ok || prefix == "" || dir == "" {
+		return fmt.Errorf("expected prefix=dir, got %q", value)
+	}
+	if strings.ContainsAny(prefix, "{} ") {
+		return fmt.Errorf("invalid mount prefix %q", prefix)
+	}
+	prefix = "/" + strings.Trim(prefix, "/") + "/"
+	if prefix == "//" {
+		return fmt.Errorf("mount prefix can't be the root, use -dir instead")
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	*m = append(*m, mount{prefix: prefix, dir: abs})
+	return nil
+}
+
+// stripPrefixHandler serves requests under prefix (like /app) from h as if they were
+// at the root, redirecting prefix itself to prefix + "/" and responding 404 to
+// anything outside it
+func stripPrefixHandler(h http.Handler, prefix string) http.Handler {
+	strip := http.StripPrefix(prefix, h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == prefix:
+			target := prefix + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+		case strings.HasPrefix(r.URL.Path, prefix+"/"):
+			strip.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
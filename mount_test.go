@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStripPrefix(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"index.html": "<p>home</p>",
+		"page.html":  "<p>page</p>",
+	})
+	handler := stripPrefixHandler(http.FileServer(http.Dir(dir)), "/app")
+
+	for _, tc := range []struct {
+		path     string
+		code     int
+		body     string
+		location string
+	}{
+		{"/app/", http.StatusOK, "<p>home</p>", ""},
+		{"/app/page.html", http.StatusOK, "<p>page</p>", ""},
+		// the file server redirects requests naming index.html to the directory
+		{"/app/index.html", http.StatusMovedPermanently, "", "./"},
+		{"/app", http.StatusMovedPermanently, "", "/app/"},
+		{"/page.html", http.StatusNotFound, "", ""},
+		{"/application/page.html", http.StatusNotFound, "", ""},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rec.Code != tc.code {
+			t.Errorf("GET %s: got status %d, want %d", tc.path, rec.Code, tc.code)
+			continue
+		}
+		if tc.body != "" && rec.Body.String() != tc.body {
+			t.Errorf("GET %s: got body %q, want %q", tc.path, rec.Body.String(), tc.body)
+		}
+		if loc := rec.Header().Get("Location"); tc.location != "" && loc != tc.location {
+			t.Errorf("GET %s: got Location %q, want %q", tc.path, loc, tc.location)
+		}
+	}
+}
+
+func TestStripPrefixBlocksKey(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{"cert.key": "secret"})
+	handler := stripPrefixHandler(protectedFileHandler(http.FileServer(http.Dir(dir)), func(p string) bool {
+		return matchesBlocked(p, []string{"*.key"})
+	}), "/app")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/app/cert.key", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /app/cert.key: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestMountExclude(t *testing.T) {
+	root := writeTestFiles(t, map[string]string{"index.html": "<p>home</p>"})
+	assets := writeTestFiles(t, map[string]string{
+		"private/a.txt": "a",
+		"public/b.txt":  "b",
+		"debug.log":     "log",
+	})
+	oldMounts, oldExcluded := mounts, excluded
+	t.Cleanup(func() { mounts, excluded = oldMounts, oldExcluded })
+	mounts = mountList{{prefix: "/assets/", dir: assets}}
+	// anchored patterns match the full URL path, mount prefix included
+	excluded = stringList{"/assets/private", "/public", "*.log"}
+
+	handler, err := BuildHandler(&Config{Dir: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(p string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, p, nil))
+		return rec
+	}
+
+	for _, tc := range []struct {
+		path string
+		code int
+	}{
+		{"/assets/private/a.txt", http.StatusNotFound},
+		{"/assets/debug.log", http.StatusNotFound},
+		{"/assets/public/b.txt", http.StatusOK},
+	} {
+		if rec := get(tc.path); rec.Code != tc.code {
+			t.Errorf("GET %s: got %d, want %d", tc.path, rec.Code, tc.code)
+		}
+	}
+	// the listing hides exactly what requests can't reach
+	listing := get("/assets/").Body.String()
+	for name, shown := range map[string]bool{"private/": false, "debug.log": false, "public/": true} {
+		if strings.Contains(listing, ">"+name+"<") != shown {
+			t.Errorf("listing of /assets/: %s shown %t, want %t", name, !shown, shown)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// notFoundWriter replaces the body of 404 responses with a custom page
+type notFoundWriter struct {
+	http.ResponseWriter
+	page        []byte
+	wroteHeader bool
+	intercepted bool
+}
+
+func (w *notFoundWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if code != http.StatusNotFound {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.intercepted = true
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Content-Length", strconv.Itoa(len(w.page)))
+	w.ResponseWriter.WriteHeader(code)
+	w.ResponseWriter.Write(w.page)
+}
+
+func (w *notFoundWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.intercepted {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *notFoundWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// notFoundHandler serves page as the body of any 404 response from h. With -spa, h
+// has already answered navigations to missing paths with the index file, so only
+// other misses reach here; see spaHandler
+func notFoundHandler(h http.Handler, page []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(&notFoundWriter{ResponseWriter: w, page: page}, r)
+	})
+}
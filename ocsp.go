@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// OCSP messages, from RFC 6960, with only the fields stapling needs
+
+type ocspCertID struct {
+	HashAlgorithm  pkix.AlgorithmIdentifier
+	IssuerNameHash []byte
+	IssuerKeyHash  []byte
+	SerialNumber   *big.Int
+}
+
+type ocspRequest struct {
+	TBSRequest struct {
+		RequestList []struct {
+			Cert ocspCertID
+		}
+	}
+}
+
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response struct {
+		ResponseType asn1.ObjectIdentifier
+		Response     []byte
+	} `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData struct {
+		Raw            asn1.RawContent
+		Version        int `asn1:"optional,default:0,explicit,tag:0"`
+		RawResponderID asn1.RawValue
+		ProducedAt     time.Time `asn1:"generalized"`
+		Responses      []ocspSingleResponse
+	}
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspSingleResponse struct {
+	CertID  ocspCertID
+	Good    asn1.Flag `asn1:"tag:0,optional"`
+	Revoked struct {
+		RevocationTime time.Time       `asn1:"generalized"`
+		Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+	} `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag `asn1:"tag:2,optional"`
+	ThisUpdate time.Time `asn1:"generalized"`
+	NextUpdate time.Time `asn1:"generalized,explicit,tag:0,optional"`
+}
+
+var (
+	oidSHA1              = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidOCSPBasicResponse = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+)
+
+// ocspSignatureAlgorithms maps the signature OIDs OCSP responders use to x509's algorithms
+var ocspSignatureAlgorithms = map[string]x509.SignatureAlgorithm{
+	"1.2.840.113549.1.1.5":  x509.SHA1WithRSA,
+	"1.2.840.113549.1.1.11": x509.SHA256WithRSA,
+	"1.2.840.113549.1.1.12": x509.SHA384WithRSA,
+	"1.2.840.113549.1.1.13": x509.SHA512WithRSA,
+	"1.2.840.10045.4.3.2":   x509.ECDSAWithSHA256,
+	"1.2.840.10045.4.3.3":   x509.ECDSAWithSHA384,
+	"1.2.840.10045.4.3.4":   x509.ECDSAWithSHA512,
+	"1.3.101.112":           x509.PureEd25519,
+}
+
+// errNoOCSP means a certificate can't be stapled, e.g. because it's self-signed
+var errNoOCSP = errors.New("certificate has no OCSP responder")
+
+// ocspCertIDFor identifies leaf to its issuer's OCSP responder
+func ocspCertIDFor(leaf, issuer *x509.Certificate) (ocspCertID, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return ocspCertID{}, err
+	}
+	nameHash := sha1.Sum(issuer.RawSubject)
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+	return ocspCertID{
+		HashAlgorithm:  pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+		IssuerNameHash: nameHash[:],
+		IssuerKeyHash:  keyHash[:],
+		SerialNumber:   leaf.SerialNumber,
+	}, nil
+}
+
+// fetchOCSPStaple asks the responder named in cert's leaf for its status, returning
+// the DER response to staple and when it should be refreshed
+func fetchOCSPStaple(ctx context.Context, cert *tls.Certificate) ([]byte, time.Time, error) {
+	if len(cert.Certificate) < 2 {
+		return nil, time.Time{}, errNoOCSP
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if len(leaf.OCSPServer) == 0 || bytes.Equal(leaf.RawIssuer, leaf.RawSubject) {
+		return nil, time.Time{}, errNoOCSP
+	}
+	id, err := ocspCertIDFor(leaf, issuer)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var req ocspRequest
+	req.TBSRequest.RequestList = append(req.TBSRequest.RequestList, struct{ Cert ocspCertID }{id})
+	body, err := asn1.Marshal(req)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, leaf.OCSPServer[0], bytes.NewReader(body))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/ocsp-request")
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("OCSP responder returned %s", resp.Status)
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	refresh, err := checkOCSPResponse(raw, leaf, issuer)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return raw, refresh, nil
+}
+
+// checkOCSPResponse verifies that raw is a signed, current "good" status for leaf,
+// returning when to refresh it: halfway to its next update
+func checkOCSPResponse(raw []byte, leaf, issuer *x509.Certificate) (time.Time, error) {
+	var resp ocspResponse
+	if _, err := asn1.Unmarshal(raw, &resp); err != nil {
+		return time.Time{}, fmt.Errorf("invalid OCSP response: %w", err)
+	}
+	if resp.Status != 0 {
+		return time.Time{}, fmt.Errorf("OCSP responder returned status %d", resp.Status)
+	}
+	if !resp.Response.ResponseType.Equal(oidOCSPBasicResponse) {
+		return time.Time{}, errors.New("unsupported OCSP response type")
+	}
+	var basic ocspBasicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return time.Time{}, fmt.Errorf("invalid OCSP response: %w", err)
+	}
+
+	// the issuer may sign responses itself, or delegate to a responder certificate
+	signer := issuer
+	if len(basic.Certificates) > 0 {
+		responder, err := x509.ParseCertificate(basic.Certificates[0].FullBytes)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if !responder.Equal(issuer) {
+			if err := responder.CheckSignatureFrom(issuer); err != nil {
+				return time.Time{}, fmt.Errorf("OCSP responder not issued by the certificate's issuer: %w", err)
+			}
+			delegated := false
+			for _, usage := range responder.ExtKeyUsage {
+				delegated = delegated || usage == x509.ExtKeyUsageOCSPSigning
+			}
+			if !delegated {
+				return time.Time{}, errors.New("OCSP responder certificate isn't authorized for OCSP signing")
+			}
+			signer = responder
+		}
+	}
+	alg, ok := ocspSignatureAlgorithms[basic.SignatureAlgorithm.Algorithm.String()]
+	if !ok {
+		return time.Time{}, fmt.Errorf("unsupported OCSP signature algorithm %s", basic.SignatureAlgorithm.Algorithm)
+	}
+	if err := signer.CheckSignature(alg, basic.TBSResponseData.Raw, basic.Signature.RightAlign()); err != nil {
+		return time.Time{}, fmt.Errorf("invalid OCSP signature: %w", err)
+	}
+
+	// responses are matched on the whole CertID that was requested, as serial
+	// numbers are only unique to an issuer
+	want, err := ocspCertIDFor(leaf, issuer)
+	if err != nil {
+		return time.Time{}, err
+	}
+	now := time.Now()
+	for _, single := range basic.TBSResponseData.Responses {
+		id := single.CertID
+		if id.SerialNumber.Cmp(want.SerialNumber) != 0 || !id.HashAlgorithm.Algorithm.Equal(want.HashAlgorithm.Algorithm) ||
+			!bytes.Equal(id.IssuerNameHash, want.IssuerNameHash) || !bytes.Equal(id.IssuerKeyHash, want.IssuerKeyHash) {
+			continue
+		}
+		if !single.Good {
+			return time.Time{}, errors.New("OCSP responder doesn't report the certificate as good")
+		}
+		if single.NextUpdate.IsZero() {
+			return now.Add(time.Hour), nil
+		}
+		if !single.NextUpdate.After(now) {
+			return time.Time{}, errors.New("OCSP response has expired")
+		}
+		return single.ThisUpdate.Add(single.NextUpdate.Sub(single.ThisUpdate) / 2), nil
+	}
+	return time.Time{}, errors.New("OCSP response doesn't cover the certificate")
+}
+
+// ocspRetryInterval is how long to wait after a failed OCSP fetch
+const ocspRetryInterval = 10 * time.Minute
+
+// stapleOCSP keeps r's certificate stapled with a current OCSP response until ctx
+// is done, fetching a new one after each reload. It stops for certificates that
+// can't be stapled, and logs failures while serving without a staple
+func (r *certReloader) stapleOCSP(ctx context.Context) {
+	for {
+		cert := r.cert.Load()
+		staple, refresh, err := fetchOCSPStaple(ctx, cert)
+		switch {
+		case errors.Is(err, errNoOCSP):
+			slog.Info("Not stapling OCSP", "cert", r.certFile, "err", err)
+			refresh = time.Time{}
+		case err != nil:
+			slog.Warn("Unable to fetch OCSP response", "cert", r.certFile, "err", err)
+			refresh = time.Now().Add(ocspRetryInterval)
+		default:
+			stapled := *cert
+			stapled.OCSPStaple = staple
+			// a reload in the meantime replaces cert, and gets its own staple below
+			r.cert.CompareAndSwap(cert, &stapled)
+			if wait := time.Until(refresh); wait < time.Minute {
+				refresh = time.Now().Add(time.Minute)
+			}
+		}
+		var timer <-chan time.Time
+		if !refresh.IsZero() {
+			timer = time.After(time.Until(refresh))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer:
+		case <-r.reloaded:
+		}
+	}
+}
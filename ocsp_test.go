@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// testIssued returns a certificate made from template, signed by parent's key (or
+// its own, if parent is nil), along with its key
+func testIssued(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestOCSPStaple(t *testing.T) {
+	now := time.Now()
+	ca, caKey := testIssued(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil, nil)
+	otherCA, otherKey := testIssued(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Other CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil, nil)
+	delegate := func(usage []x509.ExtKeyUsage) (*x509.Certificate, crypto.Signer) {
+		return testIssued(t, &x509.Certificate{
+			SerialNumber: big.NewInt(3),
+			Subject:      pkix.Name{CommonName: "Test OCSP Responder"},
+			NotBefore:    now.Add(-time.Hour),
+			NotAfter:     now.Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  usage,
+		}, ca, caKey)
+	}
+	responder, responderKey := delegate([]x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning})
+	unauthorized, unauthorizedKey := delegate([]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth})
+
+	// respond makes the responder's answer to a request for serial
+	var respond func(serial *big.Int) ([]byte, int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(body)
+		if r.Header.Get("Content-Type") != "application/ocsp-request" || err != nil {
+			t.Errorf("invalid OCSP request (Content-Type %q): %v", r.Header.Get("Content-Type"), err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		raw, code := respond(req.SerialNumber)
+		w.WriteHeader(code)
+		w.Write(raw)
+	}))
+	t.Cleanup(server.Close)
+
+	leaf, leafKey := testIssued(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		OCSPServer:   []string{server.URL},
+	}, ca, caKey)
+	cert := &tls.Certificate{Certificate: [][]byte{leaf.Raw, ca.Raw}, PrivateKey: leafKey}
+
+	thisUpdate, nextUpdate := now.Add(-time.Hour).Truncate(time.Second), now.Add(3*time.Hour).Truncate(time.Second)
+	signed := func(template ocsp.Response, issuer, signer *x509.Certificate, key crypto.Signer) func(*big.Int) ([]byte, int) {
+		return func(serial *big.Int) ([]byte, int) {
+			if template.SerialNumber == nil {
+				template.SerialNumber = serial
+			}
+			if template.ThisUpdate.IsZero() {
+				template.ThisUpdate, template.NextUpdate = thisUpdate, nextUpdate
+			}
+			raw, err := ocsp.CreateResponse(issuer, signer, template, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return raw, http.StatusOK
+		}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		respond func(*big.Int) ([]byte, int)
+		err     string
+	}{
+		{"signed by the issuer", signed(ocsp.Response{Status: ocsp.Good}, ca, ca, caKey), ""},
+		{"signed by a delegated responder", signed(ocsp.Response{Status: ocsp.Good, Certificate: responder}, ca, responder, responderKey), ""},
+		{"responder without OCSP signing", signed(ocsp.Response{Status: ocsp.Good, Certificate: unauthorized}, ca, unauthorized, unauthorizedKey), "isn't authorized"},
+		{"responder from another issuer", signed(ocsp.Response{Status: ocsp.Good, Certificate: otherCA}, ca, otherCA, otherKey), "not issued by"},
+		{"signed by another key", signed(ocsp.Response{Status: ocsp.Good}, ca, ca, otherKey), "invalid OCSP signature"},
+		{"revoked", signed(ocsp.Response{Status: ocsp.Revoked, RevokedAt: now.Add(-time.Minute)}, ca, ca, caKey), "doesn't report the certificate as good"},
+		{"expired", signed(ocsp.Response{Status: ocsp.Good, ThisUpdate: now.Add(-2 * time.Hour), NextUpdate: now.Add(-time.Hour)}, ca, ca, caKey), "has expired"},
+		{"another serial", signed(ocsp.Response{Status: ocsp.Good, SerialNumber: big.NewInt(99)}, ca, ca, caKey), "doesn't cover"},
+		{"another issuer's serial", signed(ocsp.Response{Status: ocsp.Good}, otherCA, otherCA, otherKey), "invalid OCSP signature"},
+		{"error status", func(*big.Int) ([]byte, int) { return ocsp.InternalErrorErrorResponse, http.StatusOK }, "status 2"},
+		{"HTTP error", func(*big.Int) ([]byte, int) { return nil, http.StatusServiceUnavailable }, "503"},
+		{"garbage", func(*big.Int) ([]byte, int) { return []byte("not DER"), http.StatusOK }, "invalid OCSP response"},
+	} {
+		respond = tc.respond
+		staple, refresh, err := fetchOCSPStaple(context.Background(), cert)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: got error %v, want one containing %q", tc.name, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if parsed, err := ocsp.ParseResponseForCert(staple, leaf, ca); err != nil || parsed.Status != ocsp.Good {
+			t.Errorf("%s: staple doesn't parse as good: %v", tc.name, err)
+		}
+		if want := thisUpdate.Add(2 * time.Hour); !refresh.Equal(want) {
+			t.Errorf("%s: got refresh at %v, want halfway to the next update at %v", tc.name, refresh, want)
+		}
+	}
+
+	// certificates that can't be stapled are reported as such, without a request
+	respond = func(*big.Int) ([]byte, int) {
+		t.Error("unexpected OCSP request")
+		return nil, http.StatusInternalServerError
+	}
+	for name, c := range map[string]*tls.Certificate{
+		"leaf without its issuer": {Certificate: [][]byte{leaf.Raw}},
+		"self-signed":             testCertificate(t, "example.com"),
+	} {
+		if _, _, err := fetchOCSPStaple(context.Background(), c); !errors.Is(err, errNoOCSP) {
+			t.Errorf("%s: got error %v, want %v", name, err, errNoOCSP)
+		}
+	}
+
+	// the reloader staples the response to the certificate it serves
+	respond = signed(ocsp.Response{Status: ocsp.Good}, ca, ca, caKey)
+	dir := t.TempDir()
+	keyDER, err := x509.MarshalPKCS8PrivateKey(leafKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	chain := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})...)
+	if err := os.WriteFile(certFile, chain, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		reloader.stapleOCSP(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		served, _ := reloader.GetCertificate(nil)
+		if len(served.OCSPStaple) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no OCSP response was stapled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
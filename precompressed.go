@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// precompressedExts maps the encodings negotiated by precompressedHandler to their
+// sidecar file extensions, in order of preference
+var precompressedExts = map[string]string{"br": ".br", "gzip": ".gz"}
+
+// precompressedHandler serves a file's .br or .gz sidecar from fsys, when one exists
+// and the client accepts its encoding, with the original file's content type
+func precompressedHandler(h http.Handler, fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || strings.HasSuffix(r.URL.Path, "/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		info, err := fs.Stat(fsys, name)
+		if err != nil || info.IsDir() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		addVary(w.Header(), "Accept-Encoding")
+		var available []string
+		for _, enc := range []string{"br", "gzip"} {
+			if sidecar, err := fs.Stat(fsys, name+precompressedExts[enc]); err == nil && sidecar.Mode().IsRegular() {
+				available = append(available, enc)
+			}
+		}
+		enc := negotiateEncoding(r, available...)
+		if enc == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		f, err := fsys.Open(name + precompressedExts[enc])
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		defer f.Close()
+		sidecar, err := f.Stat()
+		content, ok := f.(io.ReadSeeker)
+		if err != nil || !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ctype := mime.TypeByExtension(path.Ext(name))
+		if ctype == "" {
+			// sniffing would only see the compressed bytes
+			ctype = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", ctype)
+		w.Header().Set("Content-Encoding", enc)
+		// ServeContent leaves out Content-Length for encoded content, which HEAD
+		// requests and streamed GETs would otherwise go without
+		if r.Header.Get("Range") == "" {
+			w.Header().Set("Content-Length", strconv.FormatInt(sidecar.Size(), 10))
+		}
+		http.ServeContent(w, r, name, sidecar.ModTime(), content)
+	})
+}
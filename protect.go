@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// dotfileExceptions are dot-prefixed names that stay reachable with -block-dotfiles
+var dotfileExceptions = map[string]bool{
+	".well-known": true,
+}
+
+// hasDotfile reports whether any segment of the clean URL path p starts with a dot
+func hasDotfile(p string) bool {
+	for _, segment := range strings.Split(p, "/") {
+		if strings.HasPrefix(segment, ".") && !dotfileExceptions[segment] {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesBlocked reports whether the clean URL path p matches any of patterns. Patterns
+// without a slash match file names anywhere (*.key); others match the path from the
+// root, or any directory above it (secrets/* blocks everything below secrets)
+func matchesBlocked(p string, patterns []string) bool {
+	rel := strings.TrimPrefix(p, "/")
+	name := path.Base(p)
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+			continue
+		}
+		pattern = strings.TrimPrefix(pattern, "/")
+		for candidate := rel; candidate != "." && candidate != ""; candidate = path.Dir(candidate) {
+			if ok, _ := path.Match(pattern, candidate); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesExcluded reports whether the clean URL path p matches any of patterns, each
+// compared against every run of as many consecutive path segments, so that it also
+// excludes everything below a match (node_modules/* hides the contents of every
+// node_modules directory, at any depth). Patterns starting with a slash only match
+// from the root
+func matchesExcluded(p string, patterns []string) bool {
+	segments := strings.Split(strings.Trim(p, "/"), "/")
+	for _, pattern := range patterns {
+		anchored := strings.HasPrefix(pattern, "/")
+		pattern = strings.Trim(pattern, "/")
+		n := strings.Count(pattern, "/") + 1
+		for i := 0; i+n <= len(segments); i++ {
+			if ok, _ := path.Match(pattern, strings.Join(segments[i:i+n], "/")); ok {
+				return true
+			}
+			if anchored {
+				break
+			}
+		}
+	}
+	return false
+}
+
+// validatePatterns checks patterns are well-formed before they're used for matching
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// blockedFileHandler responds 404 to requests under root for any of files (absolute
+// paths), whether or not they exist. Requests that resolve to the same file as one of
+// them, as it was at startup or as it is now, are refused too, so alternate spellings,
+// symlinks, hard links and case-insensitive filesystems can't reach them, and a file
+// replaced by renaming another over it is still covered. Unrelated files with similar
+// names are unaffected
+func blockedFileHandler(h http.Handler, root string, files []string) http.Handler {
+	var loaded []os.FileInfo
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			loaded = append(loaded, info)
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := filepath.Join(root, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+		if isBlockedFile(name, files, loaded) {
+			http.NotFound(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// isBlockedFile reports whether name is one of files, or the same file as one of them
+// was when loaded or is now
+func isBlockedFile(name string, files []string, loaded []os.FileInfo) bool {
+	for _, f := range files {
+		if name == f {
+			return true
+		}
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return false
+	}
+	for _, f := range loaded {
+		if os.SameFile(info, f) {
+			return true
+		}
+	}
+	for _, f := range files {
+		// re-stat in case the file was replaced since startup
+		if current, err := os.Stat(f); err == nil && os.SameFile(info, current) {
+			return true
+		}
+	}
+	return false
+}
+
+// extensionHandler responds 404 to requests for files in fsys whose extension isn't
+// one of exts (like .pdf), letting directories through for index files and listings.
+// With spa, navigations to missing paths are let through too, for spaHandler to answer
+// with the index file. Extensions are compared case-insensitively
+func extensionHandler(h http.Handler, fsys fs.FS, exts []string, spa bool) http.Handler {
+	allowed := map[string]bool{}
+	for _, ext := range exts {
+		allowed["."+strings.ToLower(strings.TrimPrefix(ext, "."))] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := path.Clean("/" + r.URL.Path)
+		if !strings.HasSuffix(r.URL.Path, "/") && !allowed[strings.ToLower(path.Ext(p))] {
+			name := strings.TrimPrefix(p, "/")
+			if name == "" {
+				name = "."
+			}
+			info, err := fs.Stat(fsys, name)
+			fallback := spa && errors.Is(err, fs.ErrNotExist) && isNavigation(r)
+			if !fallback && (err != nil || !info.IsDir()) {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// protectedFileHandler responds 404 to requests for files that shouldn't be served,
+// those whose clean URL path hidden reports true for
+func protectedFileHandler(h http.Handler, hidden func(string) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hidden(path.Clean("/" + r.URL.Path)) {
+			http.NotFound(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// hiddenFS leaves the paths hidden reports true for out of directory listings
+type hiddenFS struct {
+	fs.FS
+	hidden func(string) bool
+}
+
+func (h hiddenFS) Open(name string) (fs.File, error) {
+	f, err := h.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	// only directories are wrapped, so files keep their Seek for ranges
+	if d, ok := f.(fs.ReadDirFile); ok {
+		if info, err := f.Stat(); err == nil && info.IsDir() {
+			return &hiddenDir{ReadDirFile: d, dir: path.Join("/", name), hidden: h.hidden}, nil
+		}
+	}
+	return f, nil
+}
+
+// hiddenDir is a directory from hiddenFS, filtering the entries it reads
+type hiddenDir struct {
+	fs.ReadDirFile
+	dir    string
+	hidden func(string) bool
+}
+
+func (d *hiddenDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	var kept []fs.DirEntry
+	for {
+		entries, err := d.ReadDirFile.ReadDir(n)
+		for _, entry := range entries {
+			if !d.hidden(path.Join(d.dir, entry.Name())) {
+				kept = append(kept, entry)
+			}
+		}
+		// with n > 0, keep reading until there's something to return
+		if n <= 0 || len(kept) > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
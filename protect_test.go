@@ -0,0 +1,210 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlockedFileHandler(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"cert.key", "mycert.key", "foo/page.txt"} {
+		os.MkdirAll(filepath.Join(root, filepath.Dir(name)), 0755)
+		if err := os.WriteFile(filepath.Join(root, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := blockedFileHandler(http.FileServer(http.Dir(root)), root, []string{filepath.Join(root, "cert.key")})
+
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/cert.key", http.StatusNotFound},
+		{"/./cert.key", http.StatusNotFound},
+		{"/foo/../cert.key", http.StatusNotFound},
+		{"//cert.key", http.StatusNotFound},
+		{"/mycert.key", http.StatusOK},
+		{"/foo/page.txt", http.StatusOK},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rec.Code != tc.want {
+			t.Errorf("GET %s: got %d, want %d", tc.path, rec.Code, tc.want)
+		}
+	}
+}
+
+func TestBlockedFileHandlerLink(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "cert.key"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("cert.key", filepath.Join(root, "alias.txt")); err != nil {
+		t.Skip("symlinks unsupported: ", err)
+	}
+	handler := blockedFileHandler(http.FileServer(http.Dir(root)), root, []string{filepath.Join(root, "cert.key")})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/alias.txt", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /alias.txt: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestBlockedFileHandlerReplaced(t *testing.T) {
+	root := t.TempDir()
+	key := filepath.Join(root, "cert.key")
+	if err := os.WriteFile(key, []byte("old secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	handler := blockedFileHandler(http.FileServer(http.Dir(root)), root, []string{key})
+	get := func(p string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, p, nil))
+		return rec.Code
+	}
+
+	// the key is replaced the way editors and certificate tools do, by renaming a new
+	// file over it, leaving the old file under another name
+	if err := os.Link(key, filepath.Join(root, "old.txt")); err != nil {
+		t.Skip("hard links unsupported: ", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "new.tmp"), []byte("new secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(root, "new.tmp"), filepath.Join(root, "new.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(root, "new.tmp"), key); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/cert.key", "/new.txt", "/old.txt"} {
+		if code := get(p); code != http.StatusNotFound {
+			t.Errorf("after replacing the key, GET %s: got %d, want %d", p, code, http.StatusNotFound)
+		}
+	}
+
+	// while it's missing, its path stays blocked for whatever takes its place
+	if err := os.Remove(key); err != nil {
+		t.Fatal(err)
+	}
+	if code := get("/cert.key"); code != http.StatusNotFound {
+		t.Errorf("after removing the key, GET /cert.key: got %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestSNIKeysBlocked(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"default.key", "example.key", "page.txt"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldSNI, oldMethods := sniCerts, allowedMethods
+	t.Cleanup(func() { sniCerts, allowedMethods = oldSNI, oldMethods })
+	sniCerts = sniList{{host: "example.com", cert: filepath.Join(root, "example.crt"), key: filepath.Join(root, "example.key")}}
+	allowedMethods = []string{http.MethodGet}
+
+	for _, withDefault := range []bool{true, false} {
+		defaultKey := ""
+		if withDefault {
+			defaultKey = filepath.Join(root, "default.key")
+		}
+		keys, err := keyFiles(defaultKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler := dirHandler(root, "", keys)
+		get := func(p string) int {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, p, nil))
+			return rec.Code
+		}
+		want := http.StatusOK
+		if withDefault {
+			want = http.StatusNotFound
+		}
+		if code := get("/default.key"); code != want {
+			t.Errorf("default key %t, GET /default.key: got %d, want %d", withDefault, code, want)
+		}
+		if code := get("/example.key"); code != http.StatusNotFound {
+			t.Errorf("default key %t, GET /example.key: got %d, want %d", withDefault, code, http.StatusNotFound)
+		}
+		if code := get("/page.txt"); code != http.StatusOK {
+			t.Errorf("default key %t, GET /page.txt: got %d, want %d", withDefault, code, http.StatusOK)
+		}
+	}
+
+	// renewing the SNI key by renaming a new one over it leaves it blocked
+	keys, err := keyFiles("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := dirHandler(root, "", keys)
+	if err := os.WriteFile(filepath.Join(root, "renewed.tmp"), []byte("renewed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(root, "renewed.tmp"), filepath.Join(root, "example.key")); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/example.key", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("after renewing, GET /example.key: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestOnlyExt(t *testing.T) {
+	root := writeTestFiles(t, map[string]string{
+		"index.html":      "<p>home</p>",
+		"photo.PNG":       "png",
+		"doc.pdf":         "pdf",
+		"notes.txt":       "txt",
+		"secret.pdf":      "secret",
+		"docs/index.html": "<p>docs</p>",
+		"archive.pdf/a":   "a",
+	})
+	oldExts, oldBlocked, oldSPA := onlyExts, blocked, spa
+	t.Cleanup(func() { onlyExts, blocked, spa = oldExts, oldBlocked, oldSPA })
+	onlyExts = "png, .pdf"
+	blocked = stringList{"secret.pdf"}
+
+	for _, tc := range []struct {
+		spa    bool
+		path   string
+		accept string
+		code   int
+	}{
+		{false, "/photo.PNG", "", http.StatusOK},
+		{false, "/doc.pdf", "", http.StatusOK},
+		{false, "/notes.txt", "", http.StatusNotFound},
+		{false, "/index.html", "", http.StatusNotFound},
+		{false, "/", "", http.StatusOK},
+		{false, "/docs/", "", http.StatusOK},
+		{false, "/docs", "", http.StatusMovedPermanently},
+		{false, "/docs/index.html", "", http.StatusNotFound},
+		{false, "/secret.pdf", "", http.StatusNotFound},
+		{false, "/archive.pdf/a", "", http.StatusNotFound},
+		{false, "/route", "text/html", http.StatusNotFound},
+		{true, "/route", "text/html", http.StatusOK},
+		{true, "/route", "*/*", http.StatusNotFound},
+		{true, "/notes.txt", "text/html", http.StatusNotFound},
+	} {
+		spa = tc.spa
+		handler, err := BuildHandler(&Config{Dir: root})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.code {
+			t.Errorf("-only-ext png,pdf with -spa %t, GET %s: got %d, want %d", tc.spa, tc.path, rec.Code, tc.code)
+		}
+	}
+}
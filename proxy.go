@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+type proxyRoute struct {
+	prefix string
+	target *url.URL
+}
+
+// proxyList collects repeated -proxy prefix=url flags
+type proxyList []proxyRoute
+
+func (p *proxyList) String() string {
+	var parts []string
+	for _, route := range *p {
+		parts = append(parts, route.prefix+"="+route.target.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+func (p *proxyList) Set(value string) error {
+	prefix, target, ok := strings.Cut(value, "=")
+	if !ok || prefix == "" || target == "" {
+		return fmt.Errorf("expected prefix=url, got %q", value)
+	}
+	if strings.ContainsAny(prefix, "{} ") {
+		return fmt.Errorf("invalid proxy prefix %q", prefix)
+	}
+	prefix = "/" + strings.Trim(prefix, "/") + "/"
+	if prefix == "//" {
+		return fmt.Errorf("proxy prefix can't be the root")
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("proxy target must be an http or https URL, got %q", target)
+	}
+	*p = append(*p, proxyRoute{prefix: prefix, target: u})
+	return nil
+}
+
+// proxyHandler forwards requests to target, adding X-Forwarded-* headers and
+// responding 502 when the upstream can't be reached
+func proxyHandler(target *url.URL) http.Handler {
+	return &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.SetXForwarded()
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			slog.Error("Proxy error", "upstream", target.String(), "err", err)
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		},
+	}
+}
+
+// proxyRoutesHandler sends requests under each route's prefix to its upstream,
+// and everything else to h. The prefix is stripped unless keepPrefix is set
+func proxyRoutesHandler(h http.Handler, routes []proxyRoute, keepPrefix bool) http.Handler {
+	mux := http.NewServeMux()
+	for _, route := range routes {
+		slog.Info("Proxying", "prefix", route.prefix, "upstream", route.target.String())
+		var proxy http.Handler = proxyHandler(route.target)
+		if !keepPrefix {
+			proxy = http.StripPrefix(strings.TrimSuffix(route.prefix, "/"), proxy)
+		}
+		mux.Handle(route.prefix, proxy)
+	}
+	mux.Handle("/", h)
+	return mux
+}
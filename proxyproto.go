@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout is how long a connection has to send its PROXY protocol header
+const proxyHeaderTimeout = 10 * time.Second
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var errNoProxyHeader = errors.New("missing PROXY protocol header")
+
+var errUntrustedProxy = errors.New("PROXY protocol connection from an untrusted peer")
+
+// proxyProtoListener reads the PROXY protocol header (v1 or v2) a load balancer
+// sends ahead of each connection, reporting the client it names as the RemoteAddr.
+// Only peers that trusted reports true for may send one; connections from others
+// are handled as if they had sent none, so clients can't pick their address.
+// Without require, connections that don't start with a header are served as-is
+type proxyProtoListener struct {
+	net.Listener
+	require bool
+	trusted func(ip string) bool
+}
+
+func (ln *proxyProtoListener) Accept() (net.Conn, error) {
+	c, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	ip, _, _ := net.SplitHostPort(c.RemoteAddr().String())
+	return &proxyProtoConn{Conn: c, r: bufio.NewReader(c), require: ln.require, trusted: ln.trusted(ip)}, nil
+}
+
+// proxyProtoConn reads its header on first use rather than in Accept, so a slow
+// client can't hold up the listener
+type proxyProtoConn struct {
+	net.Conn
+	r       *bufio.Reader
+	require bool
+	trusted bool
+	once    sync.Once
+	remote  net.Addr
+	err     error
+}
+
+func (c *proxyProtoConn) readHeader() {
+	if !c.trusted {
+		if c.require {
+			c.err = errUntrustedProxy
+			slog.Debug("Rejected PROXY protocol connection", "remote", c.Conn.RemoteAddr().String(), "err", c.err)
+			c.Conn.Close()
+		}
+		return
+	}
+	c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+	c.remote, c.err = parseProxyHeader(c.r, c.require)
+	if c.err != nil {
+		slog.Debug("Rejected PROXY protocol connection", "remote", c.Conn.RemoteAddr().String(), "err", c.err)
+		c.Conn.Close()
+	}
+}
+
+func (c *proxyProtoConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// parseProxyHeader consumes a PROXY protocol header from r, returning the source
+// address it gives, or nil if it doesn't give one (like a health check from the
+// load balancer itself, or no header when one isn't required)
+func parseProxyHeader(r *bufio.Reader, require bool) (net.Addr, error) {
+	if sig, err := r.Peek(len(proxyV2Signature)); err == nil && bytes.Equal(sig, proxyV2Signature) {
+		return parseProxyV2(r)
+	}
+	if start, err := r.Peek(6); err == nil && string(start) == "PROXY " {
+		return parseProxyV1(r)
+	}
+	if require {
+		return nil, errNoProxyHeader
+	}
+	return nil, nil
+}
+
+// parseProxyV1 reads a text header like "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+func parseProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("PROXY v1 header too long")
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY v1 header %q", strings.TrimSpace(string(line)))
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("invalid PROXY v1 source %s:%s", fields[2], fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// parseProxyV2 reads a binary header, only taking the source from TCP over IPv4 or IPv6
+func parseProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", header[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	// LOCAL connections come from the load balancer itself
+	if header[12]&0x0f == 0 {
+		return nil, nil
+	}
+	switch header[13] {
+	case 0x11:
+		if len(body) < 12 {
+			return nil, errors.New("short PROXY v2 IPv4 address")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 0x21:
+		if len(body) < 36 {
+			return nil, errors.New("short PROXY v2 IPv6 address")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// proxyV2Header builds a binary PROXY header with command (0 for LOCAL, 1 for PROXY),
+// family and the address body given
+func proxyV2Header(command, family byte, body []byte) string {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x20|command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(body)))
+	return string(append(header, body...))
+}
+
+func TestParseProxyHeader(t *testing.T) {
+	ipv4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb}
+	ipv6 := make([]byte, 36)
+	copy(ipv6, net.ParseIP("2001:db8::1"))
+	copy(ipv6[16:], net.ParseIP("2001:db8::2"))
+	binary.BigEndian.PutUint16(ipv6[32:], 56324)
+	binary.BigEndian.PutUint16(ipv6[34:], 443)
+
+	for _, tc := range []struct {
+		name    string
+		input   string
+		require bool
+		remote  string
+		err     bool
+	}{
+		{"v1 TCP4", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET", false, "192.0.2.1:56324", false},
+		{"v1 TCP6", "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\nGET", false, "[2001:db8::1]:56324", false},
+		{"v1 UNKNOWN", "PROXY UNKNOWN\r\nGET", true, "", false},
+		{"v1 invalid address", "PROXY TCP4 192.0.2 198.51.100.1 56324 443\r\nGET", false, "", true},
+		{"v1 invalid port", "PROXY TCP4 192.0.2.1 198.51.100.1 65536 443\r\nGET", false, "", true},
+		{"v1 missing fields", "PROXY TCP4 192.0.2.1 56324\r\nGET", false, "", true},
+		{"v1 unknown protocol", "PROXY UDP4 192.0.2.1 198.51.100.1 56324 443\r\nGET", false, "", true},
+		{"v1 without CRLF", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\nGET", false, "", true},
+		{"v1 too long", "PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n", false, "", true},
+		{"v1 truncated", "PROXY TCP4 192.0.2.1", false, "", true},
+		{"v2 IPv4", proxyV2Header(1, 0x11, ipv4) + "GET", false, "192.0.2.1:56324", false},
+		{"v2 IPv6", proxyV2Header(1, 0x21, ipv6) + "GET", false, "[2001:db8::1]:56324", false},
+		{"v2 IPv4 with TLVs", proxyV2Header(1, 0x11, append(ipv4, 0x04, 0x00, 0x01, 0xff)) + "GET", false, "192.0.2.1:56324", false},
+		{"v2 LOCAL", proxyV2Header(0, 0x11, ipv4) + "GET", true, "", false},
+		{"v2 UNSPEC", proxyV2Header(1, 0x00, nil) + "GET", true, "", false},
+		{"v2 short IPv4", proxyV2Header(1, 0x11, ipv4[:8]), false, "", true},
+		{"v2 short IPv6", proxyV2Header(1, 0x21, ipv6[:20]), false, "", true},
+		{"v2 truncated body", proxyV2Header(1, 0x11, ipv4)[:20], false, "", true},
+		{"v2 truncated header", proxyV2Header(1, 0x11, ipv4)[:14], false, "", true},
+		{"v2 version 1", strings.Replace(proxyV2Header(1, 0x11, ipv4), "\x21", "\x11", 1), false, "", true},
+		{"no header", "GET / HTTP/1.1\r\n", false, "", false},
+		{"no header, required", "GET / HTTP/1.1\r\n", true, "", true},
+	} {
+		r := bufio.NewReader(strings.NewReader(tc.input))
+		addr, err := parseProxyHeader(r, tc.require)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: got error %v, want error %t", tc.name, err, tc.err)
+			continue
+		}
+		remote := ""
+		if addr != nil {
+			remote = addr.String()
+		}
+		if remote != tc.remote {
+			t.Errorf("%s: got source %q, want %q", tc.name, remote, tc.remote)
+		}
+		if err == nil && tc.remote != "" {
+			if rest, _ := io.ReadAll(r); string(rest) != "GET" {
+				t.Errorf("%s: left %q after the header, want %q", tc.name, rest, "GET")
+			}
+		}
+	}
+}
+
+func TestProxyProtoListener(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		trusted, require bool
+		header           string
+		remote           string // "" when the connection should be refused
+	}{
+		{"trusted v1", true, false, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", "192.0.2.1:56324"},
+		{"trusted v2", true, true, proxyV2Header(1, 0x11, []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb}), "192.0.2.1:56324"},
+		{"trusted without a header", true, false, "", "127.0.0.1"},
+		{"trusted without a header, required", true, true, "", ""},
+		{"trusted with an invalid header", true, false, "PROXY TCP4 nonsense\r\n", ""},
+		{"untrusted without a header", false, false, "", "127.0.0.1"},
+		{"untrusted without a header, required", false, true, "", ""},
+		{"untrusted with a header, required", false, true, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", ""},
+	} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		trusted := tc.trusted
+		srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.RemoteAddr))
+		})}
+		go srv.Serve(&proxyProtoListener{Listener: ln, require: tc.require, trusted: func(string) bool { return trusted }})
+
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(conn, tc.header+"GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		remote := ""
+		if err == nil {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			remote = string(body)
+			if tc.remote != "" && !strings.Contains(tc.remote, ":") {
+				remote, _, _ = net.SplitHostPort(remote)
+			}
+		}
+		if remote != tc.remote {
+			t.Errorf("%s: got remote address %q (err %v), want %q", tc.name, remote, err, tc.remote)
+		}
+		conn.Close()
+		srv.Close()
+	}
+
+	// clients that aren't trusted can't pick their address by sending a header
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.NotFoundHandler()}
+	t.Cleanup(func() { srv.Close() })
+	go srv.Serve(&proxyProtoListener{Listener: ln, trusted: func(string) bool { return false }})
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	if resp, err := http.ReadResponse(bufio.NewReader(conn), nil); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("untrusted header: got %v (err %v), want it read as a bad request", resp, err)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// preloadTypes maps asset extensions to the "as" value of their preload links
+var preloadTypes = map[string]string{
+	".js": "script", ".mjs": "script", ".css": "style",
+	".woff": "font", ".woff2": "font", ".ttf": "font", ".otf": "font",
+	".png": "image", ".jpg": "image", ".jpeg": "image", ".gif": "image", ".webp": "image", ".avif": "image", ".svg": "image",
+}
+
+// loadPushManifest reads a JSON object mapping request paths to the assets they use,
+// checking that every asset exists under root
+func loadPushManifest(file, root string) (map[string][]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var manifest map[string][]string
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	for page, assets := range manifest {
+		for _, asset := range assets {
+			if !strings.HasPrefix(asset, "/") {
+				return nil, fmt.Errorf("%s: asset %q for %s must be an absolute path", file, asset, page)
+			}
+			if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(path.Clean(asset)))); err != nil {
+				return nil, fmt.Errorf("%s: asset for %s: %w", file, page, err)
+			}
+		}
+	}
+	return manifest, nil
+}
+
+// preloadLink formats a Link header value preloading asset
+func preloadLink(asset string) string {
+	link := "<" + asset + ">; rel=preload"
+	if as, ok := preloadTypes[strings.ToLower(path.Ext(asset))]; ok {
+		link += "; as=" + as
+		if as == "font" {
+			link += "; crossorigin"
+		}
+	}
+	return link
+}
+
+// findPusher looks through wrapping ResponseWriters for one that supports server push
+func findPusher(w http.ResponseWriter) (http.Pusher, bool) {
+	for {
+		if p, ok := w.(http.Pusher); ok {
+			return p, true
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil, false
+		}
+		w = u.Unwrap()
+	}
+}
+
+// pushHandler advertises the assets listed in manifest for each page with preload
+// links, and pushes them to HTTP/2 clients that allow it
+func pushHandler(h http.Handler, manifest map[string][]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assets, ok := manifest[r.URL.Path]
+		if !ok || r.Method != http.MethodGet {
+			h.ServeHTTP(w, r)
+			return
+		}
+		for _, asset := range assets {
+			w.Header().Add("Link", preloadLink(asset))
+		}
+		if pusher, ok := findPusher(w); ok && r.ProtoMajor == 2 {
+			for _, asset := range assets {
+				// fails with http.ErrNotSupported when the client disabled push
+				if err := pusher.Push(asset, nil); err != nil {
+					break
+				}
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
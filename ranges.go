@@ -0,0 +1,37 @@
+package main
+
+import "net/http"
+
+// noRangesWriter hides the file server's Accept-Ranges advertisement
+type noRangesWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *noRangesWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Del("Accept-Ranges")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *noRangesWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *noRangesWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// noRangesHandler ignores Range requests so h always sends full responses
+func noRangesHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del("Range")
+		r.Header.Del("If-Range")
+		h.ServeHTTP(&noRangesWriter{ResponseWriter: w}, r)
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMultiRangeThroughMiddleware(t *testing.T) {
+	old := logOutput
+	logOutput = io.Discard
+	t.Cleanup(func() { logOutput = old })
+
+	body := strings.Repeat("abcdefghij", 100)
+	dir := writeTestFiles(t, map[string]string{"media.bin": body, "page.html": "<body>" + body + "</body>"})
+	fsys := os.DirFS(dir)
+	files := http.FileServer(http.FS(fsys))
+
+	for _, tc := range []struct {
+		name    string
+		handler http.Handler
+	}{
+		{"plain", files},
+		{"gzip", gzipHandler(files, false)},
+		{"precompressed", precompressedHandler(files, fsys)},
+		{"strong etag", strongETagHandler(files, fsys)},
+		{"cache", cacheHandler(files, &cachePolicy{fallback: "max-age=60"}, false)},
+		{"no etag", cacheHandler(files, &cachePolicy{}, true)},
+		{"livereload", livereloadHandler(files, "")},
+		{"headers", headersHandler(files, responseHeaders(true, nil), "gomoose")},
+		{"throttle", throttleHandler(files, 1<<30)},
+		{"access log", accessLogHandler(files, "common", true)},
+		{"all", accessLogHandler(gzipHandler(livereloadHandler(cacheHandler(strongETagHandler(files, fsys), &cachePolicy{}, false), ""), false), "json", true)},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/media.bin", nil)
+		req.Header.Set("Range", "bytes=0-99,200-299")
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		tc.handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusPartialContent {
+			t.Errorf("%s: got status %d, want %d", tc.name, rec.Code, http.StatusPartialContent)
+			continue
+		}
+		if enc := rec.Header().Get("Content-Encoding"); enc != "" {
+			t.Errorf("%s: got Content-Encoding %q", tc.name, enc)
+		}
+		mediaType, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+		if err != nil || mediaType != "multipart/byteranges" {
+			t.Errorf("%s: got Content-Type %q", tc.name, rec.Header().Get("Content-Type"))
+			continue
+		}
+		parts := multipart.NewReader(rec.Body, params["boundary"])
+		for _, want := range []struct{ contentRange, data string }{
+			{"bytes 0-99/1000", body[0:100]},
+			{"bytes 200-299/1000", body[200:300]},
+		} {
+			part, err := parts.NextPart()
+			if err != nil {
+				t.Errorf("%s: reading part: %v", tc.name, err)
+				break
+			}
+			data, _ := io.ReadAll(part)
+			if cr := part.Header.Get("Content-Range"); cr != want.contentRange || string(data) != want.data {
+				t.Errorf("%s: got part %q with %d bytes, want %q", tc.name, cr, len(data), want.contentRange)
+			}
+		}
+		if _, err := parts.NextPart(); err != io.EOF {
+			t.Errorf("%s: expected exactly two parts, got %v", tc.name, err)
+		}
+	}
+}
+
+func TestLivereloadIgnoresRangesOfPages(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{"page.html": "<body>page</body>"})
+	req := httptest.NewRequest(http.MethodGet, "/page.html", nil)
+	req.Header.Set("Range", "bytes=0-3")
+	rec := httptest.NewRecorder()
+	livereloadHandler(http.FileServer(http.Dir(dir)), "").ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), livereloadPath) {
+		t.Errorf("got %d %q, want the whole page with the script", rec.Code, rec.Body.String())
+	}
+}
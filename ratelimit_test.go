@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves when told to
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter(rate float64, burst int) (*rateLimiter, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: map[string]*bucket{}, now: clock.now}, clock
+}
+
+func TestRateLimiterBurstAndRefill(t *testing.T) {
+	l, clock := newTestLimiter(2, 3)
+
+	// a new client can burst, then has to wait for a token at the refill rate
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow("a"); !ok {
+			t.Fatalf("request %d of the burst refused", i+1)
+		}
+	}
+	ok, wait := l.allow("a")
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("past the burst: got %t, wait %v, want a refusal with 500ms to wait", ok, wait)
+	}
+	if ok, _ := l.allow("b"); !ok {
+		t.Error("another client was limited by the first")
+	}
+
+	clock.advance(250 * time.Millisecond)
+	if ok, wait := l.allow("a"); ok || wait != 250*time.Millisecond {
+		t.Errorf("after 250ms: got %t, wait %v, want a refusal with 250ms to wait", ok, wait)
+	}
+	clock.advance(250 * time.Millisecond)
+	if ok, _ := l.allow("a"); !ok {
+		t.Error("after 500ms: refused, want a refilled token")
+	}
+
+	// idle clients refill up to the burst and no further
+	clock.advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow("a"); !ok {
+			t.Fatalf("after idling: request %d of the burst refused", i+1)
+		}
+	}
+	if ok, _ := l.allow("a"); ok {
+		t.Error("after idling: allowed more than the burst")
+	}
+}
+
+func TestRateLimiterEvict(t *testing.T) {
+	l, clock := newTestLimiter(1, 2)
+	l.allow("idle")
+	clock.advance(time.Second)
+	l.allow("busy")
+	clock.advance(time.Second)
+	l.evict()
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("a refilled bucket wasn't evicted")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("a bucket still refilling was evicted")
+	}
+}
+
+func TestRateLimitHandler(t *testing.T) {
+	l, clock := newTestLimiter(0.5, 1)
+	handler := rateLimitHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), l)
+	get := func(remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("192.0.2.1:1000"); rec.Code != http.StatusOK {
+		t.Fatalf("first request: got %d", rec.Code)
+	}
+	// the port doesn't matter, only the client's IP
+	rec := get("192.0.2.1:2000")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("second request: got %d with Retry-After %q, want 429 with 2", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := get("192.0.2.2:1000"); rec.Code != http.StatusOK {
+		t.Errorf("another client: got %d", rec.Code)
+	}
+	clock.advance(1500 * time.Millisecond)
+	if rec := get("192.0.2.1:1000"); rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("rounding up the wait: got Retry-After %q, want 1", rec.Header().Get("Retry-After"))
+	}
+	clock.advance(500 * time.Millisecond)
+	if rec := get("192.0.2.1:1000"); rec.Code != http.StatusOK {
+		t.Errorf("after the wait: got %d", rec.Code)
+	}
+}
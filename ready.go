@@ -0,0 +1,16 @@
+package main
+
+import "os"
+
+// notifyReady writes a newline to f and closes it, the readiness protocol used by s6
+// and similar service managers
+func notifyReady(f *os.File) error {
+	if f == nil {
+		return os.ErrInvalid
+	}
+	_, err := f.Write([]byte("\n"))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
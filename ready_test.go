@@ -0,0 +1,28 @@
+package main
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+func TestNotifyReady(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	// w is handed over whole rather than as a descriptor, since its cleanup would
+	// otherwise close the number again later, after it's reused by another test
+	if err := notifyReady(w); err != nil {
+		t.Fatal(err)
+	}
+	// notifyReady closed the write end, so this reads to EOF
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "\n" {
+		t.Errorf("got %q, want a newline", got)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// httpsRedirectHandler redirects requests made without SSL to their HTTPS equivalent on
+// sslPort, at the host the client asked for, passing SSL requests on to h. -sslhost is
+// only the address the SSL server binds, like 0.0.0.0, which clients can't necessarily
+// reach, so it's never used here
+func httpsRedirectHandler(h http.Handler, sslPort int, code int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		target := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			target = h
+		}
+		target = strings.Trim(target, "[]")
+		if sslPort != 443 {
+			target = net.JoinHostPort(target, strconv.Itoa(sslPort))
+		} else if strings.Contains(target, ":") {
+			target = "[" + target + "]"
+		}
+		u := *r.URL
+		u.Scheme = "https"
+		u.Host = target
+		http.Redirect(w, r, u.String(), code)
+	})
+}
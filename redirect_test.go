@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPSRedirect(t *testing.T) {
+	for _, tc := range []struct {
+		host    string
+		sslPort int
+		target  string
+		want    string
+	}{
+		{"example.com", 443, "/a/b?c=d", "https://example.com/a/b?c=d"},
+		{"example.com:8080", 443, "/", "https://example.com/"},
+		{"example.com:8080", 8443, "/page", "https://example.com:8443/page"},
+		{"[::1]:8080", 443, "/", "https://[::1]/"},
+		{"[::1]:8080", 8443, "/", "https://[::1]:8443/"},
+		{"192.0.2.1", 8443, "/x", "https://192.0.2.1:8443/x"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+		req.Host = tc.host
+		rec := httptest.NewRecorder()
+		httpsRedirectHandler(http.NotFoundHandler(), tc.sslPort, http.StatusPermanentRedirect).ServeHTTP(rec, req)
+		if rec.Code != http.StatusPermanentRedirect {
+			t.Errorf("%s%s: got status %d", tc.host, tc.target, rec.Code)
+		}
+		if got := rec.Header().Get("Location"); got != tc.want {
+			t.Errorf("%s%s: got Location %q, want %q", tc.host, tc.target, got, tc.want)
+		}
+	}
+}
+
+// -sslhost is a bind address, which must never leak into redirects
+func TestHTTPSRedirectIgnoresBindAddress(t *testing.T) {
+	old := sslHost
+	sslHost = "0.0.0.0"
+	t.Cleanup(func() { sslHost = old })
+	req := httptest.NewRequest(http.MethodGet, "/path", nil)
+	req.Host = "www.example.com"
+	rec := httptest.NewRecorder()
+	httpsRedirectHandler(http.NotFoundHandler(), 8443, http.StatusMovedPermanently).ServeHTTP(rec, req)
+	if got, want := rec.Header().Get("Location"), "https://www.example.com:8443/path"; got != want {
+		t.Errorf("got Location %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// liveFlags can be changed by reloading the config file on SIGHUP; the rest
+// only take effect on restart
+var liveFlags = map[string]bool{
+	"header":          true,
+	"server-header":   true,
+	"secure-headers":  true,
+	"csp":             true,
+	"csp-report-only": true,
+	"block":           true,
+	"exclude":         true,
+	"block-dotfiles":  true,
+	"log-format":      true,
+	"log-ranges":      true,
+	"quiet":           true,
+	"log-level":       true,
+}
+
+// liveSettings holds the values of liveFlags, so a failed reload can restore them
+type liveSettings struct {
+	customHeaders   headerList
+	serverHeader    string
+	secureHeadersOn bool
+	csp             string
+	cspReportOnly   bool
+	blocked         stringList
+	excluded        stringList
+	blockDotfiles   bool
+	logFormat       string
+	logRanges       bool
+	quiet           bool
+	logLevelName    string
+}
+
+func currentSettings() liveSettings {
+	return liveSettings{customHeaders, serverHeader, secureHeadersOn, csp, cspReportOnly, blocked, excluded, blockDotfiles, logFormat, logRanges, quiet, logLevelName}
+}
+
+func (s liveSettings) restore() {
+	customHeaders, serverHeader, secureHeadersOn, csp, cspReportOnly = s.customHeaders, s.serverHeader, s.secureHeadersOn, s.csp, s.cspReportOnly
+	blocked, excluded, blockDotfiles = s.blocked, s.excluded, s.blockDotfiles
+	logFormat, logRanges, quiet, logLevelName = s.logFormat, s.logRanges, s.quiet, s.logLevelName
+}
+
+// reloadableLayer is a middleware built from the current flag values, which is
+// rebuilt after the config file is reloaded
+type reloadableLayer struct {
+	next    http.Handler
+	build   func(http.Handler) http.Handler
+	current atomic.Pointer[http.Handler]
+}
+
+func (l *reloadableLayer) rebuild() {
+	h := l.build(l.next)
+	l.current.Store(&h)
+}
+
+func (l *reloadableLayer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*l.current.Load()).ServeHTTP(w, r)
+}
+
+var reloadableLayers []*reloadableLayer
+
+// reloadable wraps h with the middleware from build, rebuilding it on each reload.
+// Without -config there is nothing to reload, so the middleware is built just once
+func reloadable(h http.Handler, build func(http.Handler) http.Handler) http.Handler {
+	if configFile == "" {
+		return build(h)
+	}
+	l := &reloadableLayer{next: h, build: build}
+	l.rebuild()
+	reloadableLayers = append(reloadableLayers, l)
+	return l
+}
+
+// reloadConfig re-reads the config file, applying changes to liveFlags and logging
+// any other changes as ignored. On error the current settings are kept
+func reloadConfig(path string) error {
+	values, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	live := map[string][]string{}
+	var ignored []string
+	for name, v := range values {
+		f := flag.Lookup(name)
+		switch {
+		case f == nil || name == "config":
+			return fmt.Errorf("%s: unknown key %q", path, name)
+		case liveFlags[name]:
+			live[name] = v
+		case !presetFlags[name] && f.Value.String() != strings.Join(v, ","):
+			ignored = append(ignored, name)
+		}
+	}
+
+	old := currentSettings()
+	// settings removed from the file go back to their defaults
+	customHeaders, blocked, excluded = nil, nil, nil
+	for name := range liveFlags {
+		f := flag.Lookup(name)
+		if !presetFlags[name] && name != "header" && name != "block" && name != "exclude" {
+			f.Value.Set(f.DefValue)
+		}
+	}
+	if presetFlags["header"] {
+		customHeaders = old.customHeaders
+	}
+	if presetFlags["block"] {
+		blocked = old.blocked
+	}
+	if presetFlags["exclude"] {
+		excluded = old.excluded
+	}
+	err = applyFlagValues(live)
+	if err == nil && logFormat != "common" && logFormat != "json" {
+		err = fmt.Errorf("invalid log format %q", logFormat)
+	}
+	if err == nil {
+		err = validatePatterns(blocked)
+	}
+	if err == nil {
+		err = validatePatterns(excluded)
+	}
+	var level slog.Level
+	if err == nil {
+		level, err = resolveLogLevel(logLevelName, quiet, verbose)
+	}
+	if err != nil {
+		old.restore()
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	if len(ignored) > 0 {
+		sort.Strings(ignored)
+		slog.Warn("Ignoring changes that need a restart", "flags", strings.Join(ignored, ", "))
+	}
+	setLogLevel(level)
+	for _, l := range reloadableLayers {
+		l.rebuild()
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadConfig(t *testing.T) {
+	restoreFlags(t)
+	old, oldConfig, oldLayers := currentSettings(), configFile, reloadableLayers
+	t.Cleanup(func() {
+		old.restore()
+		configFile, reloadableLayers = oldConfig, oldLayers
+	})
+	// flags set by other tests would otherwise count as given on the command line
+	presetFlags = map[string]bool{}
+	reloadableLayers = nil
+
+	root := writeTestFiles(t, map[string]string{
+		"page.txt": "page",
+		"a.secret": "secret",
+		"b.env":    "env",
+	})
+	configFile = filepath.Join(t.TempDir(), "gomoose.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("header:\n  - 'X-Test: one'\nblock:\n  - '*.secret'\n")
+	if err := loadConfigFile(configFile); err != nil {
+		t.Fatal(err)
+	}
+	handler, err := BuildHandler(&Config{Dir: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(stage, header string, codes map[string]int) {
+		t.Helper()
+		for p, code := range codes {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, p, nil))
+			if rec.Code != code || rec.Header().Get("X-Test") != header {
+				t.Errorf("%s, GET %s: got %d with X-Test %q, want %d with %q", stage, p, rec.Code, rec.Header().Get("X-Test"), code, header)
+			}
+		}
+	}
+	check("initial config", "one", map[string]int{"/page.txt": 200, "/a.secret": 404, "/b.env": 200})
+
+	startPort := port
+	write("header:\n  - 'X-Test: two'\nblock:\n  - '*.env'\nport: 9999\n")
+	if err := reloadConfig(configFile); err != nil {
+		t.Fatal(err)
+	}
+	check("reloaded", "two", map[string]int{"/page.txt": 200, "/a.secret": 200, "/b.env": 404})
+	if port != startPort {
+		t.Errorf("reloading changed -port to %d, want it left at %d until a restart", port, startPort)
+	}
+
+	write("header:\n  - 'X-Test: three'\nblock:\n  - '['\n")
+	if err := reloadConfig(configFile); err == nil {
+		t.Error("reloading an invalid -block: got no error")
+	}
+	check("after a failed reload", "two", map[string]int{"/page.txt": 200, "/a.secret": 200, "/b.env": 404})
+
+	// settings removed from the file go back to their defaults
+	write("quiet: false\n")
+	if err := reloadConfig(configFile); err != nil {
+		t.Fatal(err)
+	}
+	check("settings removed", "", map[string]int{"/page.txt": 200, "/a.secret": 200, "/b.env": 200})
+}
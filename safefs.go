@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// safeFS serves a directory like os.DirFS, double-checking that every path it opens
+// resolves to somewhere inside it. Paths that don't are reported as not existing, so
+// requests for them get the same 404 as any other missing file. Unless
+// followSymlinks is set, that includes paths leading through symlinks out of the
+// directory; links within it still work
+type safeFS struct {
+	fs.FS
+	root           string
+	followSymlinks bool
+}
+
+func newSafeFS(dir string, followSymlinks bool) *safeFS {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		root = dir
+	}
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		root = real
+	}
+	return &safeFS{FS: os.DirFS(dir), root: root, followSymlinks: followSymlinks}
+}
+
+// within reports whether path is root or inside it
+func within(root, path string) bool {
+	return path == root || strings.HasPrefix(path, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator))
+}
+
+func (s *safeFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	full := filepath.Join(s.root, filepath.FromSlash(name))
+	if !within(s.root, full) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if !s.followSymlinks {
+		// missing files are left for Open to report
+		if real, err := filepath.EvalSymlinks(full); err == nil && !within(s.root, real) {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+	}
+	return s.FS.Open(name)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSafeFS(t *testing.T) {
+	outside := writeTestFiles(t, map[string]string{"passwd": "root:x:0:0"})
+	root := writeTestFiles(t, map[string]string{"index.html": "home", "sub/page.txt": "page"})
+	links := map[string]string{
+		"escape":      filepath.Join(outside, "passwd"),
+		"escapedir":   outside,
+		"inside":      filepath.Join(root, "sub", "page.txt"),
+		"sub/relback": "../../" + filepath.Base(outside) + "/passwd",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Skip("symlinks unsupported: ", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		follow bool
+		want   error
+	}{
+		{"index.html", false, nil},
+		{"sub/page.txt", false, nil},
+		{"../../etc/passwd", false, fs.ErrInvalid},
+		{"sub/../../passwd", false, fs.ErrInvalid},
+		{"escape", false, fs.ErrNotExist},
+		{"escapedir/passwd", false, fs.ErrNotExist},
+		{"sub/relback", false, fs.ErrNotExist},
+		{"inside", false, nil},
+		{"escape", true, nil},
+	} {
+		f, err := newSafeFS(root, tc.follow).Open(tc.name)
+		if err == nil {
+			f.Close()
+		}
+		if (tc.want == nil && err != nil) || (tc.want != nil && !errors.Is(err, tc.want)) {
+			t.Errorf("Open(%q) with follow %t: got %v, want %v", tc.name, tc.follow, err, tc.want)
+		}
+	}
+
+	// through the file server, crafted paths are cleaned to stay within the root
+	handler := http.FileServer(http.FS(newSafeFS(root, false)))
+	for _, p := range []string{"/../../etc/passwd", "/escape", "/escapedir/passwd", "/sub/relback"} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.URL.Path = p
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s: got %d, want %d", p, rec.Code, http.StatusNotFound)
+		}
+	}
+}
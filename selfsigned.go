@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+// minRSABits is the smallest -key-bits accepted for generated RSA keys
+const minRSABits = 2048
+
+// generateKey makes a private key of keyType ("ecdsa" for P-256, or "rsa" of keyBits),
+// returning it along with its PEM encoding
+func generateKey(keyType string, keyBits int) (crypto.Signer, []byte, error) {
+	switch keyType {
+	case "ecdsa":
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+	case "rsa":
+		if keyBits < minRSABits {
+			return nil, nil, fmt.Errorf("RSA keys need at least %d bits, got %d", minRSABits, keyBits)
+		}
+		key, err := rsa.GenerateKey(rand.Reader, keyBits)
+		if err != nil {
+			return nil, nil, err
+		}
+		der := x509.MarshalPKCS1PrivateKey(key)
+		return key, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: der}), nil
+	}
+	return nil, nil, fmt.Errorf("invalid key type %q, expected ecdsa or rsa", keyType)
+}
+
+// generateSelfSignedCert makes a certificate for hosts (DNS names or IP addresses)
+// valid for days and signed by its own new key, returning the certificate and key as PEM
+func generateSelfSignedCert(hosts []string, org string, days int, keyType string, keyBits int) (certPEM, keyPEM []byte, err error) {
+	if len(hosts) == 0 {
+		return nil, nil, errors.New("no hosts to generate a certificate for")
+	}
+	if days <= 0 {
+		return nil, nil, fmt.Errorf("invalid validity of %d days", days)
+	}
+	key, keyPEM, err := generateKey(keyType, keyBits)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(0, 0, days),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if org != "" {
+		template.Subject.Organization = []string{org}
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	if keyType == "rsa" {
+		// RSA key exchange, still used by some older clients, encrypts with the key
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM, nil
+}
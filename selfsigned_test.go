@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+func TestGenerateSelfSignedCert(t *testing.T) {
+	for _, tt := range []struct {
+		keyType string
+		keyBits int
+		pemType string
+	}{
+		{"ecdsa", 0, "EC PRIVATE KEY"},
+		{"rsa", 2048, "RSA PRIVATE KEY"},
+	} {
+		certPEM, keyPEM, err := generateSelfSignedCert([]string{"localhost"}, "", 365, tt.keyType, tt.keyBits)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.keyType, err)
+		}
+		if block, _ := pem.Decode(keyPEM); block == nil || block.Type != tt.pemType {
+			t.Errorf("%s: key isn't %s PEM", tt.keyType, tt.pemType)
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.keyType, err)
+		}
+		switch key := cert.Leaf.PublicKey.(type) {
+		case *ecdsa.PublicKey:
+			if tt.keyType != "ecdsa" || key.Curve.Params().Name != "P-256" {
+				t.Errorf("%s: got an ECDSA %s key", tt.keyType, key.Curve.Params().Name)
+			}
+		case *rsa.PublicKey:
+			if tt.keyType != "rsa" || key.N.BitLen() != tt.keyBits {
+				t.Errorf("%s: got a %d bit RSA key", tt.keyType, key.N.BitLen())
+			}
+		}
+		if err := cert.Leaf.VerifyHostname("localhost"); err != nil {
+			t.Errorf("%s: %v", tt.keyType, err)
+		}
+		pool := x509.NewCertPool()
+		pool.AddCert(cert.Leaf)
+		if _, err := cert.Leaf.Verify(x509.VerifyOptions{Roots: pool, DNSName: "localhost"}); err != nil {
+			t.Errorf("%s: not self-signed: %v", tt.keyType, err)
+		}
+	}
+}
+
+func TestSelfSignedCertTemplate(t *testing.T) {
+	certPEM, keyPEM, err := generateSelfSignedCert([]string{"example.lan", "192.168.1.20", "::1"}, "Example Org", 30, "ecdsa", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := cert.Leaf
+	if leaf.Subject.CommonName != "example.lan" || len(leaf.Subject.Organization) != 1 || leaf.Subject.Organization[0] != "Example Org" {
+		t.Errorf("got subject %s", leaf.Subject)
+	}
+	if len(leaf.DNSNames) != 1 || leaf.DNSNames[0] != "example.lan" {
+		t.Errorf("got DNS names %q", leaf.DNSNames)
+	}
+	if len(leaf.IPAddresses) != 2 || leaf.IPAddresses[0].String() != "192.168.1.20" || leaf.IPAddresses[1].String() != "::1" {
+		t.Errorf("got IP addresses %v", leaf.IPAddresses)
+	}
+	for _, host := range []string{"example.lan", "192.168.1.20", "::1"} {
+		if err := leaf.VerifyHostname(host); err != nil {
+			t.Error(err)
+		}
+	}
+	if days := leaf.NotAfter.Sub(leaf.NotBefore).Hours() / 24; days < 30 || days > 31 {
+		t.Errorf("valid for %.1f days, want 30", days)
+	}
+}
+
+func TestGenerateSelfSignedCertErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		hosts   []string
+		days    int
+		keyType string
+		keyBits int
+	}{
+		{"unknown key type", []string{"localhost"}, 365, "dsa", 2048},
+		{"small RSA key", []string{"localhost"}, 365, "rsa", 1024},
+		{"no hosts", nil, 365, "ecdsa", 0},
+		{"no validity", []string{"localhost"}, 0, "ecdsa", 0},
+	} {
+		if _, _, err := generateSelfSignedCert(tt.hosts, "", tt.days, tt.keyType, tt.keyBits); err == nil {
+			t.Errorf("%s: got no error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Server runs the HTTP and HTTPS listeners and shuts them down together
+type Server struct {
+	httpServer       *http.Server
+	tlsServer        *http.Server
+	h3Server         *http3.Server
+	sslCert          string
+	sslKey           string
+	certificate      *tls.Certificate
+	certSource       string // where certificate came from, for logging
+	acme             *autocert.Manager
+	shutdownTimeout  time.Duration
+	certReload       bool
+	ocsp             bool
+	maxConns         int
+	proxyProtocol    bool
+	proxyRequire     bool
+	proxyTrusted     func(ip string) bool
+	retryBind        int
+	ticketRotation   time.Duration
+	rejectUnknownSNI bool
+	active           atomic.Int64
+
+	// httpAddrs are the addresses the HTTP server listens on, defaulting to its Addr
+	httpAddrs []string
+	// quit is closed to shut down, as for a -shutdown-token request
+	quit chan struct{}
+
+	ctx           context.Context
+	cancel        context.CancelFunc
+	httpListeners []net.Listener
+	tlsListener   net.Listener
+	h3Conn        net.PacketConn
+	errChan       chan error
+	running       int
+	readyOnce     sync.Once
+	ready         chan struct{}
+}
+
+// Config is where a Server listens and what it serves. Everything else (compression,
+// headers, access control and the rest) is taken from the flags
+type Config struct {
+	// Dir is the directory served, along with any -mount and -vhost directories
+	Dir string
+	// FS, if set, is served in place of Dir, as from an embed.FS. Options that write
+	// to or watch the directory, like -allow-upload and -livereload, can't be used with it
+	FS fs.FS
+
+	// Host and Port are the HTTP server's address, with port 0 choosing a free port.
+	// Listen, if set, lists the addresses to listen on instead
+	Host   string
+	Port   int
+	Listen []string
+	NoHTTP bool
+
+	// SSL enables the SSL server on SSLHost and SSLPort, serving the cert and key from
+	// SSLCert and SSLKey, or Certificate if set. CertSource names where Certificate
+	// came from, for logging
+	SSL         bool
+	SSLHost     string
+	SSLPort     int
+	SSLCert     string
+	SSLKey      string
+	Certificate *tls.Certificate
+	CertSource  string
+}
+
+// NewServer sets up a server for config, returning an error for invalid settings.
+// It doesn't listen until Start is called
+func NewServer(config *Config) (*Server, error) {
+	srv := &Server{sslCert: config.SSLCert, sslKey: config.SSLKey, certificate: config.Certificate, certSource: config.CertSource, shutdownTimeout: shutdownTimeout, certReload: certReload, ocsp: ocspStapling, maxConns: maxConns, proxyProtocol: proxyProtocol, proxyRequire: proxyProtocolRequire, proxyTrusted: isTrustedProxy, retryBind: retryBind, ticketRotation: ticketRotation, rejectUnknownSNI: rejectUnknownSNI, quit: make(chan struct{})}
+	if acmeOn {
+		var err error
+		dirs := servedDirs(config.Dir)
+		if config.FS != nil {
+			dirs = dirs[1:]
+		}
+		srv.acme, err = newACMEManager(parseList(acmeDomains), acmeCache, dirs)
+		if err != nil {
+			return nil, err
+		}
+		if config.NoHTTP || config.Port != 80 {
+			slog.Warn("-acme can only answer HTTP-01 challenges over HTTP on port 80, leaving TLS-ALPN-01 on the SSL port")
+		}
+	}
+	handler, lr, err := buildHandler(config, srv)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range config.Listen {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("invalid -listen: %w", err)
+		}
+	}
+	srv.httpAddrs = config.Listen
+	if !config.NoHTTP {
+		srv.httpServer = newHTTPServer(listenAddr(config.Host, config.Port), handler)
+	}
+	if config.SSL {
+		tlsConf, err := tlsConfig()
+		if err != nil {
+			return nil, fmt.Errorf("unable to configure SSL: %w", err)
+		}
+		srv.tlsServer = newHTTPServer(listenAddr(config.SSLHost, config.SSLPort), handler)
+		srv.tlsServer.TLSConfig = tlsConf
+		if http3On {
+			srv.h3Server = newHTTP3Server(handler)
+			srv.tlsServer.Handler = altSvcHandler(handler, srv.h3Server)
+		}
+		if noHTTP2 {
+			// a non-nil TLSNextProto stops net/http from configuring HTTP/2
+			tlsConf.NextProtos = []string{"http/1.1"}
+			srv.tlsServer.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+			slog.Debug("SSL protocols", "proto", tlsConf.NextProtos)
+		} else {
+			slog.Debug("SSL protocols", "proto", []string{"h2", "http/1.1"})
+		}
+	}
+	if lr != nil {
+		for _, server := range srv.servers() {
+			server.RegisterOnShutdown(lr.close)
+		}
+	}
+	return srv, nil
+}
+
+// NewServerFS sets up a server for config that serves fsys, like an embed.FS, in place
+// of config.Dir
+func NewServerFS(config *Config, fsys fs.FS) (*Server, error) {
+	c := *config
+	c.FS = fsys
+	return NewServer(&c)
+}
+
+// trackConn counts open connections, for reporting on shutdown
+func (s *Server) trackConn(c net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		s.active.Add(1)
+	case http.StateHijacked, http.StateClosed:
+		s.active.Add(-1)
+	}
+}
+
+func (s *Server) servers() []*http.Server {
+	var servers []*http.Server
+	for _, srv := range []*http.Server{s.httpServer, s.tlsServer} {
+		if srv != nil {
+			servers = append(servers, srv)
+		}
+	}
+	return servers
+}
+
+// Start binds the listeners and serves in the background, returning once connections
+// can be accepted. The server shuts down when ctx is done; call Wait to block until then.
+// A server can only be started once
+func (s *Server) Start(ctx context.Context) error {
+	if s.ctx != nil {
+		return errors.New("server has already been started")
+	}
+	// the background work tied to ctx stops on Shutdown, even when it's called directly
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	var limiter *connLimiter
+	if s.maxConns > 0 {
+		limiter = newConnLimiter(s.maxConns)
+	}
+	wrap := func(ln net.Listener) net.Listener {
+		if s.proxyProtocol {
+			ln = &proxyProtoListener{Listener: ln, require: s.proxyRequire, trusted: s.proxyTrusted}
+		}
+		if limiter != nil {
+			ln = limiter.wrap(ln)
+		}
+		return ln
+	}
+	if s.httpServer != nil {
+		addrs := s.httpAddrs
+		if len(addrs) == 0 {
+			addrs = []string{s.httpServer.Addr}
+		}
+		for _, addr := range addrs {
+			ln, err := s.listen(addr)
+			if err != nil {
+				s.closeListeners()
+				return fmt.Errorf("HTTP listening error: %w", err)
+			}
+			s.httpListeners = append(s.httpListeners, wrap(ln))
+			slog.Info("HTTP listening", "addr", ln.Addr().String(), "port", ln.Addr().(*net.TCPAddr).Port)
+		}
+	}
+	if s.tlsServer != nil {
+		if s.tlsServer.TLSConfig == nil {
+			s.tlsServer.TLSConfig = &tls.Config{}
+		}
+		err := s.loadCertificate()
+		var ln net.Listener
+		if err == nil {
+			ln, err = s.listen(s.tlsServer.Addr)
+		}
+		if err != nil {
+			s.closeListeners()
+			return fmt.Errorf("SSL listening error: %w", err)
+		}
+		s.tlsListener = wrap(ln)
+		if s.ticketRotation > 0 {
+			rotateTicketKeys(s.ctx, s.tlsServer.TLSConfig, s.ticketRotation)
+		}
+		switch {
+		case s.certificate != nil:
+			slog.Info("SSL listening", "addr", ln.Addr().String(), "port", ln.Addr().(*net.TCPAddr).Port, "cert", s.certSource)
+		case s.acme != nil:
+			slog.Info("SSL listening", "addr", ln.Addr().String(), "port", ln.Addr().(*net.TCPAddr).Port, "cert", "acme")
+		default:
+			slog.Info("SSL listening", "addr", ln.Addr().String(), "port", ln.Addr().(*net.TCPAddr).Port, "cert", s.sslCert, "key", s.sslKey)
+		}
+		if s.h3Server != nil {
+			// the HTTP/3 server takes its own copy of the TLS settings, so it's
+			// set up once they're complete
+			if s.h3Conn, err = listenHTTP3(ln.Addr()); err != nil {
+				s.closeListeners()
+				return fmt.Errorf("HTTP/3 listening error: %w", err)
+			}
+			s.h3Server.TLSConfig = s.tlsServer.TLSConfig
+			slog.Info("HTTP/3 listening", "addr", s.h3Conn.LocalAddr().String())
+		}
+	}
+
+	s.errChan = make(chan error, len(s.httpListeners)+2)
+	if s.httpServer != nil {
+		s.httpServer.ConnState = s.trackConn
+	}
+	// one server serves every HTTP listener, so shutting it down drains them all
+	for _, ln := range s.httpListeners {
+		s.running++
+		go func() {
+			if err := s.httpServer.Serve(ln); err != http.ErrServerClosed {
+				s.errChan <- fmt.Errorf("HTTP listening error on %s: %w", ln.Addr(), err)
+				return
+			}
+			s.errChan <- nil
+		}()
+	}
+	if s.tlsServer != nil {
+		s.running++
+		s.tlsServer.ConnState = s.trackConn
+		go func() {
+			if err := s.tlsServer.ServeTLS(s.tlsListener, "", ""); err != http.ErrServerClosed {
+				s.errChan <- fmt.Errorf("SSL listening error: %w", err)
+				return
+			}
+			s.errChan <- nil
+		}()
+	}
+	if s.h3Conn != nil {
+		s.running++
+		go func() {
+			if err := s.h3Server.Serve(s.h3Conn); err != http.ErrServerClosed {
+				s.errChan <- fmt.Errorf("HTTP/3 listening error: %w", err)
+				return
+			}
+			s.errChan <- nil
+		}()
+	}
+	s.Ready()
+	close(s.ready)
+	return nil
+}
+
+// Ready returns a channel that's closed once Start has bound every listener, so
+// connections made after it closes are accepted. It never closes if Start fails
+func (s *Server) Ready() <-chan struct{} {
+	s.readyOnce.Do(func() { s.ready = make(chan struct{}) })
+	return s.ready
+}
+
+// listen binds addr, retrying up to retryBind more times with a growing delay while
+// it's in use, as it can be briefly during a restart
+func (s *Server) listen(addr string) (net.Listener, error) {
+	delay := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		ln, err := net.Listen("tcp", addr)
+		if err == nil || !errors.Is(err, syscall.EADDRINUSE) {
+			return ln, err
+		}
+		if attempt >= s.retryBind {
+			return nil, fmt.Errorf("address %s is already in use, another server may be running on that port (%w)", addr, err)
+		}
+		slog.Warn("Address in use, retrying", "addr", addr, "delay", delay.String())
+		select {
+		case <-time.After(delay):
+		case <-s.ctx.Done():
+			return nil, err
+		}
+		delay = min(delay*2, 10*time.Second)
+	}
+}
+
+// loadCertificate sets up the TLS config to serve the SSL cert and key (or the
+// already loaded certificate, or those from ACME) to clients whose server name has no -sni certificate.
+// With certReload or ocsp it serves them through a reloader, which watches the files
+// or keeps an OCSP response stapled until the server stops
+func (s *Server) loadCertificate() error {
+	config := s.tlsServer.TLSConfig
+	var fallback func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	switch {
+	case s.certificate != nil:
+		fallback = func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return s.certificate, nil }
+	case s.acme != nil:
+		fallback = s.acme.GetCertificate
+		// lets the CA validate domains with TLS-ALPN-01 on this listener
+		config.NextProtos = append(config.NextProtos, acme.ALPNProto)
+	case !s.certReload && !s.ocsp:
+		cert, err := tls.LoadX509KeyPair(s.sslCert, s.sslKey)
+		if err != nil {
+			return err
+		}
+		fallback = func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return &cert, nil }
+	default:
+		reloader, err := newCertReloader(s.sslCert, s.sslKey)
+		if err != nil {
+			return err
+		}
+		fallback = reloader.GetCertificate
+		if s.certReload {
+			go reloader.watch(s.ctx, certReloadInterval)
+		}
+		if s.ocsp {
+			go reloader.stapleOCSP(s.ctx)
+		}
+	}
+	// host-specific certificates take precedence
+	sni := config.GetCertificate
+	config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if sni != nil {
+			if cert, err := sni(hello); cert != nil || err != nil {
+				return cert, err
+			}
+		}
+		cert, err := fallback(hello)
+		if err != nil || hello.ServerName == "" || cert.Leaf == nil || cert.Leaf.VerifyHostname(hello.ServerName) == nil {
+			return cert, err
+		}
+		// no certificate covers the name, which is likely a misconfigured client or DNS
+		slog.Info("Unknown SSL server name", "name", hello.ServerName, "remote", hello.Conn.RemoteAddr().String())
+		if s.rejectUnknownSNI {
+			return nil, fmt.Errorf("no certificate for server name %q", hello.ServerName)
+		}
+		return cert, nil
+	}
+	return nil
+}
+
+// Wait blocks until every listener has stopped, shutting down once Start's context is
+// done or a shutdown is requested with -shutdown-token
+func (s *Server) Wait() error {
+	var errs []error
+	for s.running > 0 {
+		select {
+		case err := <-s.errChan:
+			s.running--
+			if err != nil {
+				slog.Error("Listener stopped", "err", err)
+				errs = append(errs, err)
+			}
+		case <-s.ctx.Done():
+			slog.Info("Shutting down")
+			return errors.Join(append(errs, s.Shutdown())...)
+		case <-s.quit:
+			slog.Info("Shutting down")
+			return errors.Join(append(errs, s.Shutdown())...)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run starts the server and waits for it to stop
+func (s *Server) Run(ctx context.Context) error {
+	if err := s.Start(ctx); err != nil {
+		return err
+	}
+	return s.Wait()
+}
+
+// Addr returns the addresses the server is listening on
+func (s *Server) Addr() []net.Addr {
+	var addrs []net.Addr
+	for _, ln := range s.httpListeners {
+		addrs = append(addrs, ln.Addr())
+	}
+	if s.tlsListener != nil {
+		addrs = append(addrs, s.tlsListener.Addr())
+	}
+	return addrs
+}
+
+// HTTPAddr returns the address of the first HTTP listener, or nil if it isn't running
+func (s *Server) HTTPAddr() net.Addr {
+	if len(s.httpListeners) == 0 {
+		return nil
+	}
+	return s.httpListeners[0].Addr()
+}
+
+// closeListeners closes the listeners opened so far, when Start fails partway
+func (s *Server) closeListeners() {
+	for _, ln := range s.httpListeners {
+		ln.Close()
+	}
+	s.httpListeners = nil
+	if s.tlsListener != nil {
+		s.tlsListener.Close()
+		s.tlsListener = nil
+	}
+	if s.h3Conn != nil {
+		s.h3Conn.Close()
+		s.h3Conn = nil
+	}
+}
+
+// HTTPSAddr returns the address of the SSL listener, or nil if it isn't running
+func (s *Server) HTTPSAddr() net.Addr {
+	if s.tlsListener == nil {
+		return nil
+	}
+	return s.tlsListener.Addr()
+}
+
+// drainReportInterval is how often Shutdown logs the connections it's waiting for
+const drainReportInterval = 5 * time.Second
+
+// ActiveConnections returns the number of open client connections
+func (s *Server) ActiveConnections() int64 {
+	return s.active.Load()
+}
+
+// Shutdown stops accepting connections on every listener at once, then waits for
+// active requests to finish, logging how many connections remain every few seconds.
+// Any still open once the shutdown timeout (if nonzero) expires are closed
+func (s *Server) Shutdown() error {
+	if s.cancel != nil {
+		defer s.cancel()
+	}
+	ctx := context.Background()
+	if s.shutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.shutdownTimeout)
+		defer cancel()
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(drainReportInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				slog.Info("Waiting for connections to close", "open", s.active.Load())
+			}
+		}
+	}()
+	servers := s.servers()
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, srv := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = srv.Shutdown(ctx)
+		}()
+	}
+	var h3Err error
+	if s.h3Server != nil {
+		// HTTP/3 clients are sent a GOAWAY, and any still connected once ctx is done are
+		// closed. The server leaves its UDP socket open, so it's closed afterwards
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h3Err = s.h3Server.Shutdown(ctx)
+			if s.h3Conn != nil {
+				s.h3Conn.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	if ctx.Err() != nil {
+		slog.Warn("Shutdown timed out, closing connections", "open", s.active.Load(), "timeout", s.shutdownTimeout.String())
+		for _, srv := range servers {
+			srv.Close()
+		}
+	}
+	return errors.Join(append(errs, h3Err)...)
+}
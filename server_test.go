@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+func TestUnknownSNI(t *testing.T) {
+	cert := testCertificate(t, "example.com")
+	for _, reject := range []bool{false, true} {
+		srv := &Server{
+			tlsServer:        &http.Server{TLSConfig: &tls.Config{}},
+			certificate:      cert,
+			rejectUnknownSNI: reject,
+		}
+		if err := srv.loadCertificate(); err != nil {
+			t.Fatal(err)
+		}
+		getCertificate := srv.tlsServer.TLSConfig.GetCertificate
+
+		got, err := getCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+		if err != nil || got != cert {
+			t.Errorf("reject %t, known name: got %v, %v", reject, got, err)
+		}
+		got, err = getCertificate(&tls.ClientHelloInfo{})
+		if err != nil || got != cert {
+			t.Errorf("reject %t, no name: got %v, %v", reject, got, err)
+		}
+	}
+}
+
+func TestUnknownSNIHandshake(t *testing.T) {
+	cert := testCertificate(t, "example.com")
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.Leaf)
+	for _, reject := range []bool{false, true} {
+		srv := &Server{
+			tlsServer:        &http.Server{TLSConfig: &tls.Config{}},
+			certificate:      cert,
+			rejectUnknownSNI: reject,
+		}
+		if err := srv.loadCertificate(); err != nil {
+			t.Fatal(err)
+		}
+		ln, err := tls.Listen("tcp", "127.0.0.1:0", srv.tlsServer.TLSConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			for {
+				c, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				c.(*tls.Conn).Handshake()
+				c.Close()
+			}
+		}()
+
+		// the client checks nothing, so the handshake only fails if the server aborts it
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{ServerName: "unknown.example", InsecureSkipVerify: true})
+		if reject && err == nil {
+			conn.Close()
+			t.Errorf("reject %t: handshake for an unknown name succeeded", reject)
+		}
+		if !reject {
+			if err != nil {
+				t.Errorf("reject %t: handshake for an unknown name failed: %v", reject, err)
+			} else {
+				if got := conn.ConnectionState().PeerCertificates[0]; !got.Equal(cert.Leaf) {
+					t.Errorf("reject %t: served a different certificate", reject)
+				}
+				conn.Close()
+			}
+		}
+		conn, err = tls.Dial("tcp", ln.Addr().String(), &tls.Config{ServerName: "example.com", RootCAs: pool})
+		if err != nil {
+			t.Errorf("reject %t: handshake for a known name failed: %v", reject, err)
+		} else {
+			conn.Close()
+		}
+		ln.Close()
+	}
+}
+
+func TestReady(t *testing.T) {
+	srv := &Server{httpServer: newHTTPServer("127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))}
+	ready := srv.Ready()
+	select {
+	case <-ready:
+		t.Fatal("ready before starting")
+	default:
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- srv.Run(ctx) }()
+	select {
+	case <-ready:
+	case err := <-done:
+		t.Fatal("server stopped: ", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting to be ready")
+	}
+
+	// no sleeping: the listener accepts connections as soon as it's ready
+	resp, err := http.Get("http://" + srv.HTTPAddr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if err := srv.Start(ctx); err == nil {
+		t.Error("starting twice didn't fail")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	root := writeTestFiles(t, map[string]string{"page.txt": "hello"})
+	cert := testCertificate(t, "localhost")
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.Leaf)
+
+	srv, err := NewServer(&Config{
+		Dir:         root,
+		Host:        "127.0.0.1",
+		SSL:         true,
+		SSLHost:     "127.0.0.1",
+		Certificate: cert,
+		CertSource:  "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := srv.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if addrs := srv.Addr(); len(addrs) != 2 {
+		t.Fatalf("got addresses %v, want HTTP and SSL", addrs)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, ServerName: "localhost"}}}
+	for _, url := range []string{serverURL("http", srv.HTTPAddr()), serverURL("https", srv.HTTPSAddr())} {
+		resp, err := client.Get(url + "page.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+			t.Errorf("GET %spage.txt: got %d %q", url, resp.StatusCode, body)
+		}
+	}
+	cancel()
+	if err := srv.Wait(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewServerErrors(t *testing.T) {
+	root := t.TempDir()
+	if _, err := NewServer(&Config{Dir: root, Listen: []string{"no-port"}}); err == nil {
+		t.Error("invalid Listen address: got no error")
+	}
+	old := methods
+	t.Cleanup(func() { methods = old })
+	methods = ""
+	if _, err := NewServer(&Config{Dir: root}); err == nil {
+		t.Error("no -methods: got no error")
+	}
+}
+
+func TestNewServerFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":    {Data: []byte("<p>home</p>")},
+		"docs/a.txt":    {Data: []byte("a")},
+		".secret/c.txt": {Data: []byte("c")},
+	}
+	oldDotfiles := blockDotfiles
+	t.Cleanup(func() { blockDotfiles = oldDotfiles })
+	blockDotfiles = true
+
+	srv, err := NewServerFS(&Config{Host: "127.0.0.1"}, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := srv.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	url := serverURL("http", srv.HTTPAddr())
+	for _, tc := range []struct {
+		path string
+		code int
+		body string
+	}{
+		{"", http.StatusOK, "<p>home</p>"},
+		{"docs/a.txt", http.StatusOK, "a"},
+		{"docs/missing.txt", http.StatusNotFound, ""},
+		{".secret/c.txt", http.StatusNotFound, ""},
+	} {
+		resp, err := http.Get(url + tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tc.code || (tc.body != "" && string(body) != tc.body) {
+			t.Errorf("GET /%s: got %d %q, want %d %q", tc.path, resp.StatusCode, body, tc.code, tc.body)
+		}
+	}
+	cancel()
+	if err := srv.Wait(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBuildHandlerFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"docs/a.txt": {Data: []byte("a")},
+		"docs/b.pdf": {Data: []byte("b")},
+	}
+	oldExts, oldUpload := onlyExts, allowUpload
+	t.Cleanup(func() { onlyExts, allowUpload = oldExts, oldUpload })
+	onlyExts = "pdf"
+
+	handler, err := BuildHandler(&Config{FS: fsys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		path string
+		code int
+	}{
+		{"/docs/b.pdf", http.StatusOK},
+		{"/docs/a.txt", http.StatusNotFound},
+		{"/docs/", http.StatusOK},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rec.Code != tc.code {
+			t.Errorf("-only-ext pdf, GET %s: got %d, want %d", tc.path, rec.Code, tc.code)
+		}
+	}
+
+	// nothing can be written to an fs.FS
+	allowUpload = true
+	if _, err := BuildHandler(&Config{FS: fsys}); err == nil {
+		t.Error("-allow-upload: got no error")
+	}
+}
+
+func TestHTTP3(t *testing.T) {
+	old := http3On
+	t.Cleanup(func() { http3On = old })
+	http3On = true
+	root := writeTestFiles(t, map[string]string{"page.txt": "hello"})
+	cert := testCertificate(t, "localhost")
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.Leaf)
+
+	srv, err := NewServer(&Config{
+		Dir:         root,
+		NoHTTP:      true,
+		SSL:         true,
+		SSLHost:     "127.0.0.1",
+		Certificate: cert,
+		CertSource:  "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := srv.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	url := serverURL("https", srv.HTTPSAddr()) + "page.txt"
+	tlsConf := &tls.Config{RootCAs: pool, ServerName: "localhost"}
+
+	// HTTP/1.1 and HTTP/2 responses advertise HTTP/3 on the same port
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConf}}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	want := fmt.Sprintf(`h3=":%d"; ma=2592000`, srv.HTTPSAddr().(*net.TCPAddr).Port)
+	if got := resp.Header.Get("Alt-Svc"); got != want {
+		t.Errorf("GET %s over TCP: got Alt-Svc %q, want %q", url, got, want)
+	}
+
+	h3 := &http3.Transport{TLSClientConfig: tlsConf}
+	defer h3.Close()
+	resp, err = (&http.Client{Transport: h3}).Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.ProtoMajor != 3 || resp.StatusCode != http.StatusOK || string(body) != "hello" {
+		t.Errorf("GET %s over HTTP/3: got %s %d %q", url, resp.Proto, resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("Alt-Svc"); got != "" {
+		t.Errorf("GET %s over HTTP/3: got Alt-Svc %q, want none", url, got)
+	}
+
+	cancel()
+	if err := srv.Wait(); err != nil {
+		t.Error(err)
+	}
+	// the UDP port is released on shutdown
+	conn, err := net.ListenPacket("udp", srv.HTTPSAddr().String())
+	if err != nil {
+		t.Errorf("UDP port still in use after shutdown: %v", err)
+	} else {
+		conn.Close()
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// shutdownPath is where -shutdown-token accepts requests to stop the server
+const shutdownPath = "/._shutdown"
+
+// shutdownHandler answers POSTs carrying token in the X-Shutdown-Token header with a
+// 202, then closes quit so the server shuts down as it would on SIGINT. Only clients on
+// the loopback interface are accepted
+func shutdownHandler(token string, quit chan<- struct{}) http.Handler {
+	var once sync.Once
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil || !net.ParseIP(ip).IsLoopback() {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Shutdown-Token")), []byte(token)) != 1 {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		once.Do(func() {
+			slog.Info("Shutdown requested", "remote", r.RemoteAddr)
+			close(quit)
+		})
+	})
+}
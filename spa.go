@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// isNavigation reports whether r looks like a browser navigation to a client-side route
+func isNavigation(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	return path.Ext(r.URL.Path) == "" && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// spaHandler serves the root index file for navigations to paths that don't exist,
+// so client-side routers can handle them; missing assets still 404.
+//
+// With -404 as well, the two split misses between them: navigations (extensionless
+// GETs accepting HTML, like /route) get the index file here, and everything else
+// (like /app.js or /favicon.ico) falls through to a 404 that notFoundHandler, which
+// wraps this, replaces with the custom page. The custom page is only used for a
+// navigation if there's no index file to serve
+func spaHandler(h http.Handler, fs http.FileSystem, names []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isNavigation(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		f, err := fs.Open(path.Clean(r.URL.Path))
+		if err == nil {
+			f.Close()
+		}
+		if !os.IsNotExist(err) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		name, ok := findIndex(fs, "/", names)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		f, err = fs.Open(name)
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+	})
+}
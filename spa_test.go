@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSPAWithNotFoundPage(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"index.html": "<p>app</p>",
+		"app.js":     "app()",
+	})
+	fs := http.Dir(dir)
+	handler := notFoundHandler(spaHandler(http.FileServer(fs), fs, []string{"index.html"}), []byte("<p>custom 404</p>"))
+
+	for _, tc := range []struct {
+		path, accept string
+		code         int
+		body         string
+	}{
+		{"/missing.js", "*/*", http.StatusNotFound, "<p>custom 404</p>"},
+		{"/route", "text/html,application/xhtml+xml", http.StatusOK, "<p>app</p>"},
+		{"/favicon.ico", "text/html,*/*", http.StatusNotFound, "<p>custom 404</p>"},
+		{"/route", "application/json", http.StatusNotFound, "<p>custom 404</p>"},
+		{"/app.js", "*/*", http.StatusOK, "app()"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		req.Header.Set("Accept", tc.accept)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.code || rec.Body.String() != tc.body {
+			t.Errorf("GET %s (Accept: %s): got %d %q, want %d %q", tc.path, tc.accept, rec.Code, rec.Body.String(), tc.code, tc.body)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// throttleWriter paces the response body to rate bytes a second with a token bucket
+// holding up to chunk bytes, flushing before each wait so the client sees the
+// bytes arrive at that pace
+type throttleWriter struct {
+	http.ResponseWriter
+	ctx    context.Context
+	rate   float64
+	chunk  int
+	tokens float64
+	last   time.Time
+}
+
+// wait blocks until n bytes may be written, or the request is canceled
+func (w *throttleWriter) wait(n int) error {
+	for {
+		now := time.Now()
+		w.tokens = min(float64(w.chunk), w.tokens+now.Sub(w.last).Seconds()*w.rate)
+		w.last = now
+		if w.tokens >= float64(n) {
+			w.tokens -= float64(n)
+			return nil
+		}
+		http.NewResponseController(w.ResponseWriter).Flush()
+		timer := time.NewTimer(time.Duration((float64(n) - w.tokens) / w.rate * float64(time.Second)))
+		select {
+		case <-timer.C:
+		case <-w.ctx.Done():
+			timer.Stop()
+			return w.ctx.Err()
+		}
+	}
+}
+
+func (w *throttleWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		n := min(len(b), w.chunk)
+		if err := w.wait(n); err != nil {
+			return written, err
+		}
+		m, err := w.ResponseWriter.Write(b[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+func (w *throttleWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *throttleWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// throttleHandler slows each response body to rate bytes a second, for testing how
+// clients behave on slow networks
+func throttleHandler(h http.Handler, rate int64) http.Handler {
+	// writing a twentieth of a second's worth at a time keeps the pace smooth
+	chunk := max(1, int(rate/20))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(&throttleWriter{ResponseWriter: w, ctx: r.Context(), rate: float64(rate), chunk: chunk, last: time.Now()}, r)
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"time"
+)
+
+// ticketKeyWindow is how many session ticket keys are kept, the newest encrypting
+// new tickets and the rest still decrypting recent ones
+const ticketKeyWindow = 3
+
+// rotateTicketKeys makes config encrypt session tickets with a fresh key every
+// interval until ctx is done, so a leaked key only exposes sessions from the last
+// few intervals.
+//
+// http.Server serves a clone of its TLSConfig, so keys set on config later wouldn't
+// reach it. The keys live in a config of their own instead, which the WrapSession
+// and UnwrapSession hooks (copied into the clone) use to seal and open tickets
+func rotateTicketKeys(ctx context.Context, config *tls.Config, interval time.Duration) {
+	sealer := &tls.Config{}
+	var keys [][32]byte
+	rotate := func() {
+		var key [32]byte
+		rand.Read(key[:])
+		keys = append([][32]byte{key}, keys...)
+		if len(keys) > ticketKeyWindow {
+			keys = keys[:ticketKeyWindow]
+		}
+		sealer.SetSessionTicketKeys(keys)
+	}
+	rotate()
+	config.WrapSession = func(cs tls.ConnectionState, ss *tls.SessionState) ([]byte, error) {
+		return sealer.EncryptTicket(cs, ss)
+	}
+	config.UnwrapSession = func(identity []byte, cs tls.ConnectionState) (*tls.SessionState, error) {
+		return sealer.DecryptTicket(identity, cs)
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				rotate()
+			}
+		}
+	}()
+}
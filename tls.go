@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// clientAuthModes maps -client-auth-mode values to how client certificates are checked
+var clientAuthModes = map[string]tls.ClientAuthType{
+	"request": tls.RequestClientCert,
+	"require": tls.RequireAnyClientCert,
+	"verify":  tls.RequireAndVerifyClientCert,
+}
+
+// tlsVersions maps -tls-min and -tls-max values to protocol versions
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func parseTLSVersion(name, value string) (uint16, error) {
+	version, ok := tlsVersions[value]
+	if !ok {
+		return 0, fmt.Errorf("invalid %s %q, expected 1.0, 1.1, 1.2 or 1.3", name, value)
+	}
+	return version, nil
+}
+
+// parseCipherSuites looks up each named suite among Go's secure cipher suites
+func parseCipherSuites(names []string) ([]uint16, error) {
+	known := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	var ids []uint16
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+type sniCert struct {
+	host string
+	cert string
+	key  string
+}
+
+// sniList collects repeated -sni host=cert:key flags
+type sniList []sniCert
+
+func (l *sniList) String() string {
+	var parts []string
+	for _, c := range *l {
+		parts = append(parts, c.host+"="+c.cert+":"+c.key)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *sniList) Set(value string) error {
+	host, files, ok := strings.Cut(value, "=")
+	cert, key, ok2 := strings.Cut(files, ":")
+	if !ok || !ok2 || host == "" || cert == "" || key == "" {
+		return fmt.Errorf("expected host=cert:key, got %q", value)
+	}
+	*l = append(*l, sniCert{host: strings.ToLower(host), cert: cert, key: key})
+	return nil
+}
+
+// sniCertificates loads each mapping, returning a GetCertificate callback that picks
+// by server name (exact, then *.parent wildcard) and leaves other names to the default
+func sniCertificates(mappings sniList) (func(*tls.ClientHelloInfo) (*tls.Certificate, error), error) {
+	certs := map[string]*tls.Certificate{}
+	for _, m := range mappings {
+		cert, err := tls.LoadX509KeyPair(m.cert, m.key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m.host, err)
+		}
+		certs[m.host] = &cert
+	}
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		name := strings.ToLower(hello.ServerName)
+		if cert, ok := certs[name]; ok {
+			return cert, nil
+		}
+		if _, parent, ok := strings.Cut(name, "."); ok {
+			if cert, ok := certs["*."+parent]; ok {
+				return cert, nil
+			}
+		}
+		// nil falls back to the default certificate
+		return nil, nil
+	}, nil
+}
+
+// keyFiles returns the absolute paths of the SSL keys loaded from disk, which are never
+// to be served: key, unless it's empty, and the key of each -sni certificate
+func keyFiles(key string) ([]string, error) {
+	var keys []string
+	if key != "" {
+		keys = append(keys, key)
+	}
+	for _, m := range sniCerts {
+		keys = append(keys, m.key)
+	}
+	for i, key := range keys {
+		abs, err := filepath.Abs(key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve key %s: %w", key, err)
+		}
+		keys[i] = abs
+	}
+	return keys, nil
+}
+
+// loadCertPool reads a bundle of PEM certificates
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New(path + ": no PEM certificates found")
+	}
+	return pool, nil
+}
+
+// certPEMEnv and keyPEMEnv hold an SSL cert and key as PEM data, used in place of
+// -cert and -key so the key never has to be written to disk
+const (
+	certPEMEnv = "GOMOOSE_SSL_CERT_PEM"
+	keyPEMEnv  = "GOMOOSE_SSL_KEY_PEM"
+)
+
+// envCertificate loads the certificate from certPEMEnv and keyPEMEnv, returning nil
+// if neither is set. Both are unset afterwards, so they aren't passed on to CGI
+// scripts or other child processes
+func envCertificate() (*tls.Certificate, error) {
+	certPEM, certOK := os.LookupEnv(certPEMEnv)
+	keyPEM, keyOK := os.LookupEnv(keyPEMEnv)
+	if !certOK && !keyOK {
+		return nil, nil
+	}
+	os.Unsetenv(certPEMEnv)
+	os.Unsetenv(keyPEMEnv)
+	if !certOK || !keyOK {
+		return nil, fmt.Errorf("%s and %s must be set together", certPEMEnv, keyPEMEnv)
+	}
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("invalid PEM in %s or %s: %w", certPEMEnv, keyPEMEnv, err)
+	}
+	return &cert, nil
+}
+
+// maxStdinPEM caps the PEM bundle read for -cert -
+const maxStdinPEM = 1 << 20
+
+// stdinCertificate loads the certificate and key from a single PEM bundle in r,
+// like one piped to stdin with -cert -
+func stdinCertificate(r io.Reader) (*tls.Certificate, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxStdinPEM))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the SSL cert and key from stdin: %w", err)
+	}
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PEM on stdin, which needs both the cert and key: %w", err)
+	}
+	return &cert, nil
+}
+
+// tlsConfig builds the SSL server's TLS settings
+func tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+	var err error
+	if config.MinVersion, err = parseTLSVersion("-tls-min", tlsMin); err != nil {
+		return nil, err
+	}
+	if config.MaxVersion, err = parseTLSVersion("-tls-max", tlsMax); err != nil {
+		return nil, err
+	}
+	if config.MinVersion > config.MaxVersion {
+		return nil, fmt.Errorf("-tls-min %s is above -tls-max %s", tlsMin, tlsMax)
+	}
+	if ciphers != "" {
+		if config.CipherSuites, err = parseCipherSuites(parseList(ciphers)); err != nil {
+			return nil, err
+		}
+		if config.MinVersion == tls.VersionTLS13 {
+			slog.Warn("-ciphers has no effect with TLS 1.3, whose cipher suites aren't configurable")
+		}
+	}
+	if noSessionTickets {
+		// Go servers only resume sessions from tickets, so this turns off resumption
+		// entirely: every reconnecting client pays for a full handshake, an extra round
+		// trip and the certificate's signature, in exchange for forward secrecy not
+		// depending on ticket keys. There's no server-side session cache to disable;
+		// ClientSessionCache only applies to clients
+		config.SessionTicketsDisabled = true
+	}
+	if len(sniCerts) > 0 {
+		if config.GetCertificate, err = sniCertificates(sniCerts); err != nil {
+			return nil, err
+		}
+	}
+	if clientCA != "" {
+		mode, ok := clientAuthModes[clientAuthMode]
+		if !ok {
+			return nil, fmt.Errorf("invalid client auth mode %q, expected request, require or verify", clientAuthMode)
+		}
+		pool, err := loadCertPool(clientCA)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = mode
+	}
+	return config, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestNoSessionTickets(t *testing.T) {
+	old := noSessionTickets
+	t.Cleanup(func() { noSessionTickets = old })
+	for _, disabled := range []bool{false, true} {
+		noSessionTickets = disabled
+		config, err := tlsConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.SessionTicketsDisabled != disabled {
+			t.Errorf("-no-session-tickets=%t: got SessionTicketsDisabled %t", disabled, config.SessionTicketsDisabled)
+		}
+	}
+}
+
+// testCertificate returns a self-signed certificate for names, with its Leaf parsed
+func testCertificate(t *testing.T, names ...string) *tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestStdinCertificate(t *testing.T) {
+	certPEM, keyPEM, err := generateSelfSignedCert([]string{"example.com"}, "", 1, "ecdsa", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bundle := range [][]byte{append(certPEM, keyPEM...), append(keyPEM, certPEM...)} {
+		cert, err := stdinCertificate(bytes.NewReader(bundle))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cert.Certificate) != 1 || cert.PrivateKey == nil {
+			t.Errorf("got %d certificates and key %T, want the one certificate and its key", len(cert.Certificate), cert.PrivateKey)
+		}
+	}
+	for name, bundle := range map[string][]byte{
+		"cert only": certPEM,
+		"key only":  keyPEM,
+		"garbage":   []byte("not PEM"),
+		"empty":     nil,
+	} {
+		if _, err := stdinCertificate(bytes.NewReader(bundle)); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// uploadHandler writes the bodies of PUT requests to the matching file under root,
+// creating parent directories as needed. It responds 201 when a file is created and
+// 204 when one is replaced. os.Root keeps writes from escaping root, even via symlinks
+func uploadHandler(h http.Handler, root string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			h.ServeHTTP(w, r)
+			return
+		}
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if name == "" || strings.HasSuffix(r.URL.Path, "/") {
+			http.Error(w, "Can't upload to a directory", http.StatusConflict)
+			return
+		}
+		dir, err := os.OpenRoot(root)
+		if err != nil {
+			slog.Error("Unable to open upload directory", "dir", root, "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		defer dir.Close()
+		status := http.StatusCreated
+		if info, err := dir.Stat(name); err == nil {
+			if info.IsDir() {
+				http.Error(w, "Can't upload to a directory", http.StatusConflict)
+				return
+			}
+			status = http.StatusNoContent
+		}
+		if parent := path.Dir(name); parent != "." {
+			if err := dir.MkdirAll(parent, 0755); err != nil {
+				slog.Error("Unable to create upload directory", "path", name, "err", err)
+				http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+				return
+			}
+		}
+		// the body goes to a temporary file beside the destination, which only
+		// replaces it once the whole body has been written
+		tmp := path.Join(path.Dir(name), "."+path.Base(name)+".upload-"+randomHex(8))
+		f, err := dir.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			slog.Error("Unable to create uploaded file", "path", name, "err", err)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		_, err = io.Copy(f, r.Body)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = dir.Rename(tmp, name)
+		}
+		if err != nil {
+			dir.Remove(tmp)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
+			slog.Error("Unable to write uploaded file", "path", name, "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(status)
+	})
+}
+
+// randomHex returns n random bytes, hex-encoded
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUploadHandler(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "root")
+	outside := filepath.Join(parent, "outside")
+	for _, dir := range []string{root, outside} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(outside, "target.txt"), []byte("original"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "linkdir")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "target.txt"), filepath.Join(root, "linkfile")); err != nil {
+		t.Fatal(err)
+	}
+	handler := uploadHandler(http.NotFoundHandler(), root)
+	put := func(target, body string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, target, strings.NewReader(body)))
+		return rec.Code
+	}
+	read := func(name string) string {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+
+	if code := put("/new.txt", "one"); code != http.StatusCreated || read(filepath.Join(root, "new.txt")) != "one" {
+		t.Errorf("PUT /new.txt: got %d with %q written", code, read(filepath.Join(root, "new.txt")))
+	}
+	if code := put("/new.txt", "two"); code != http.StatusNoContent || read(filepath.Join(root, "new.txt")) != "two" {
+		t.Errorf("PUT /new.txt again: got %d with %q written", code, read(filepath.Join(root, "new.txt")))
+	}
+	if code := put("/a/b/c.txt", "c"); code != http.StatusCreated || read(filepath.Join(root, "a", "b", "c.txt")) != "c" {
+		t.Errorf("PUT /a/b/c.txt: got %d with %q written", code, read(filepath.Join(root, "a", "b", "c.txt")))
+	}
+	for _, p := range []string{"/", "/a/", "/a"} {
+		if code := put(p, "x"); code != http.StatusConflict {
+			t.Errorf("PUT %s: got %d, want %d", p, code, http.StatusConflict)
+		}
+	}
+
+	// .. can't climb out of root, and neither can links
+	if code := put("/../escape.txt", "x"); code != http.StatusCreated || read(filepath.Join(root, "escape.txt")) != "x" {
+		t.Errorf("PUT /../escape.txt: got %d, want it written inside root", code)
+	}
+	if read(filepath.Join(parent, "escape.txt")) != "" {
+		t.Error("PUT /../escape.txt wrote outside root")
+	}
+	if code := put("/linkdir/evil.txt", "x"); code == http.StatusCreated || code == http.StatusNoContent {
+		t.Errorf("PUT /linkdir/evil.txt: got %d, want it refused", code)
+	}
+	if read(filepath.Join(outside, "evil.txt")) != "" {
+		t.Error("PUT /linkdir/evil.txt wrote through the link out of root")
+	}
+	put("/linkfile", "x")
+	if got := read(filepath.Join(outside, "target.txt")); got != "original" {
+		t.Errorf("PUT /linkfile changed the file the link points to, to %q", got)
+	}
+
+	// a body over -max-body leaves nothing behind
+	limited := maxBodyHandler(handler, 4)
+	rec := httptest.NewRecorder()
+	limited.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/big.txt", strings.NewReader("too large")))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("PUT /big.txt over the limit: got %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") || e.Name() == "big.txt" {
+			t.Errorf("PUT /big.txt over the limit left %s behind", e.Name())
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/new.txt", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /new.txt: got %d, want it passed on", rec.Code)
+	}
+}
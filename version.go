@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// set for release builds with -ldflags "-X main.version=... -X main.commit=... -X main.date=..."
+var version = "dev"
+var commit = ""
+var date = ""
+
+// versionString describes the build, filling gaps from the embedded VCS info when available
+func versionString() string {
+	v, c, d, dirty := version, commit, date, false
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if c == "" {
+					c = setting.Value
+				}
+			case "vcs.time":
+				if d == "" {
+					d = setting.Value
+				}
+			case "vcs.modified":
+				dirty = setting.Value == "true"
+			}
+		}
+	}
+	if c == "" {
+		c = "unknown"
+	} else if dirty {
+		c += "-dirty"
+	}
+	if d == "" {
+		d = "unknown"
+	}
+	return fmt.Sprintf("gomoose %s (commit %s, built %s)", v, c, d)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type vhost struct {
+	host string
+	dir  string
+}
+
+// vhostList collects repeated -vhost host=dir flags
+type vhostList []vhost
+
+func (l *vhostList) String() string {
+	var parts []string
+	for _, v := range *l {
+		parts = append(parts, v.host+"="+v.dir)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *vhostList) Set(value string) error {
+	host, dir, ok := strings.Cut(value, "=")
+	if !ok || host == "" || dir == "" {
+		return fmt.Errorf("expected host=dir, got %q", value)
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if strings.Contains(strings.TrimPrefix(host, "*."), "*") || strings.ContainsAny(host, ":/ ") {
+		return fmt.Errorf("invalid host %q, expected a name like example.com or *.example.com", host)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, vhost{host: host, dir: abs})
+	return nil
+}
+
+// requestHost returns r's Host without the port, lowercased
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// vhostHandler routes requests by Host to the handler for the matching host, where
+// *.example.com matches any subdomain of example.com. Exact names win over wildcards,
+// and longer wildcards over shorter ones. Other hosts go to h
+func vhostHandler(h http.Handler, hosts map[string]http.Handler) http.Handler {
+	var wildcards []string
+	for host := range hosts {
+		if strings.HasPrefix(host, "*.") {
+			wildcards = append(wildcards, host)
+		}
+	}
+	sort.Slice(wildcards, func(i, j int) bool {
+		return len(wildcards[i]) > len(wildcards[j])
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := requestHost(r)
+		if handler, ok := hosts[host]; ok {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		for _, pattern := range wildcards {
+			if strings.HasSuffix(host, pattern[1:]) {
+				hosts[pattern].ServeHTTP(w, r)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+// davFS is a webdav.FileSystem over the directory dir, opened through os.Root so
+// neither .. nor symlinks can reach outside it. The protected keys, and anything
+// -block-dotfiles, -block or -exclude hide, act as if they don't exist. With readOnly,
+// nothing can be changed
+type davFS struct {
+	root      *os.Root
+	dir       string
+	protected []string
+	loaded    []os.FileInfo
+	readOnly  bool
+}
+
+// davName turns a WebDAV path into a name within the root
+func davName(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// hidden reports whether name is hidden like it is from file requests
+func (s *davFS) hidden(name string) bool {
+	p := path.Clean("/" + name)
+	return isHidden(p) || isBlockedFile(filepath.Join(s.dir, filepath.FromSlash(p)), s.protected, s.loaded)
+}
+
+// holdsProtected reports whether name is, or is a directory holding, a protected key
+func (s *davFS) holdsProtected(name string) bool {
+	full := filepath.Join(s.dir, filepath.FromSlash(path.Clean("/"+name)))
+	for _, f := range s.protected {
+		if within(full, f) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if s.readOnly || s.hidden(name) {
+		return os.ErrPermission
+	}
+	return s.root.Mkdir(davName(name), perm)
+}
+
+func (s *davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	writing := flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+	switch {
+	case writing && (s.readOnly || s.hidden(name)):
+		return nil, os.ErrPermission
+	case s.hidden(name):
+		return nil, os.ErrNotExist
+	}
+	f, err := s.root.OpenFile(davName(name), flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &davFile{File: f, fs: s, name: name}, nil
+}
+
+func (s *davFS) RemoveAll(ctx context.Context, name string) error {
+	switch {
+	case s.readOnly || davName(name) == "." || s.holdsProtected(name):
+		return os.ErrPermission
+	case s.hidden(name):
+		return os.ErrNotExist
+	}
+	return s.root.RemoveAll(davName(name))
+}
+
+func (s *davFS) Rename(ctx context.Context, oldName, newName string) error {
+	switch {
+	case s.readOnly || davName(oldName) == "." || s.holdsProtected(oldName) || s.hidden(newName):
+		return os.ErrPermission
+	case s.hidden(oldName):
+		return os.ErrNotExist
+	}
+	return s.root.Rename(davName(oldName), davName(newName))
+}
+
+func (s *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if s.hidden(name) {
+		return nil, os.ErrNotExist
+	}
+	return s.root.Stat(davName(name))
+}
+
+// davFile leaves hidden files out of directory listings
+type davFile struct {
+	*os.File
+	fs   *davFS
+	name string
+}
+
+func (f *davFile) Readdir(n int) ([]fs.FileInfo, error) {
+	infos, err := f.File.Readdir(n)
+	visible := infos[:0]
+	for _, info := range infos {
+		if !f.fs.hidden(path.Join(f.name, info.Name())) {
+			visible = append(visible, info)
+		}
+	}
+	return visible, err
+}
+
+// davReadMethods are the WebDAV methods that don't change anything
+var davReadMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodOptions: true, "PROPFIND": true,
+}
+
+// webdavHandler serves the directory root over WebDAV at prefix, passing other requests
+// on to h. The SSL keys in protected can't be read, listed or overwritten, whether named
+// by the request path or a COPY or MOVE Destination, and directories holding them can't
+// be copied, moved or deleted. With readOnly, only reading methods are allowed
+func webdavHandler(h http.Handler, root, prefix string, readOnly bool, protected []string) (http.Handler, error) {
+	prefix = "/" + strings.Trim(prefix, "/")
+	if prefix == "/" {
+		return nil, errors.New("-webdav-prefix can't be /, which would leave no path for the files")
+	}
+	dir, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	osRoot, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	fsys := &davFS{root: osRoot, dir: dir, protected: protected, readOnly: readOnly}
+	for _, f := range protected {
+		if info, err := os.Stat(f); err == nil {
+			fsys.loaded = append(fsys.loaded, info)
+		}
+	}
+	dav := &webdav.Handler{
+		Prefix:     prefix,
+		FileSystem: fsys,
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				slog.Debug("WebDAV request failed", "method", r.Method, "path", r.URL.Path, "err", err)
+			}
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := path.Clean("/" + r.URL.Path)
+		if p != prefix && !strings.HasPrefix(p, prefix+"/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if readOnly && !davReadMethods[r.Method] {
+			w.Header().Set("Allow", "GET, HEAD, OPTIONS, PROPFIND")
+			http.Error(w, "WebDAV is read-only", http.StatusMethodNotAllowed)
+			return
+		}
+		name := strings.TrimPrefix(p, prefix)
+		switch r.Method {
+		case "COPY", "MOVE", http.MethodDelete:
+			if fsys.holdsProtected(name) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+		}
+		if dest := r.Header.Get("Destination"); dest != "" {
+			u, err := url.Parse(dest)
+			if err != nil {
+				http.Error(w, "Invalid Destination", http.StatusBadRequest)
+				return
+			}
+			if d := path.Clean("/" + u.Path); strings.HasPrefix(d, prefix+"/") && fsys.hidden(strings.TrimPrefix(d, prefix)) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+		}
+		dav.ServeHTTP(w, r)
+	}), nil
+}
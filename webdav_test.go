@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWebDAV(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "root")
+	outside := filepath.Join(parent, "outside")
+	if err := os.Mkdir(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"page.txt":     "page",
+		"cert.key":     "key",
+		"keys/sni.key": "sni key",
+		"keys/a.txt":   "a",
+		"dir/b.txt":    "b",
+	}
+	for name, content := range files {
+		full := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	oldOn, oldPrefix, oldReadOnly, oldSNI := webdavOn, webdavPrefix, webdavReadOnly, sniCerts
+	oldUser, oldPass := authUser, authPass
+	t.Cleanup(func() {
+		webdavOn, webdavPrefix, webdavReadOnly, sniCerts = oldOn, oldPrefix, oldReadOnly, oldSNI
+		authUser, authPass = oldUser, oldPass
+	})
+	webdavOn, webdavPrefix = true, "/dav/"
+	sniCerts = sniList{{host: "example.com", cert: filepath.Join(root, "keys", "sni.crt"), key: filepath.Join(root, "keys", "sni.key")}}
+	config := &Config{Dir: root, SSL: true, SSLKey: filepath.Join(root, "cert.key")}
+
+	var handler http.Handler
+	build := func() {
+		var err error
+		if handler, err = BuildHandler(config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	do := func(method, target, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		if authUser != "" {
+			req.SetBasicAuth(authUser, authPass)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	read := func(name string) string {
+		data, _ := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+		return string(data)
+	}
+	build()
+
+	rec := do("PROPFIND", "/dav/", "", "Depth", "1")
+	if rec.Code != http.StatusMultiStatus || !strings.Contains(rec.Body.String(), "/dav/page.txt") {
+		t.Errorf("PROPFIND /dav/: got %d %q, want a listing with page.txt", rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "cert.key") {
+		t.Error("PROPFIND /dav/ listed the SSL key")
+	}
+	if rec := do("PROPFIND", "/dav/keys/", "", "Depth", "1"); strings.Contains(rec.Body.String(), "sni.key") {
+		t.Error("PROPFIND /dav/keys/ listed the SNI key")
+	}
+	if rec := do(http.MethodGet, "/dav/page.txt", ""); rec.Code != http.StatusOK || rec.Body.String() != "page" {
+		t.Errorf("GET /dav/page.txt: got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodGet, "/page.txt", ""); rec.Code != http.StatusOK || rec.Body.String() != "page" {
+		t.Errorf("GET /page.txt outside WebDAV: got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPut, "/dav/new.txt", "new"); rec.Code != http.StatusCreated || read("new.txt") != "new" {
+		t.Errorf("PUT /dav/new.txt: got %d with %q written", rec.Code, read("new.txt"))
+	}
+	if rec := do("MKCOL", "/dav/made", ""); rec.Code != http.StatusCreated {
+		t.Errorf("MKCOL /dav/made: got %d, want %d", rec.Code, http.StatusCreated)
+	}
+	if rec := do("MOVE", "/dav/new.txt", "", "Destination", "http://example.com/dav/made/moved.txt"); rec.Code != http.StatusCreated || read("made/moved.txt") != "new" {
+		t.Errorf("MOVE /dav/new.txt: got %d with %q moved", rec.Code, read("made/moved.txt"))
+	}
+	if rec := do(http.MethodDelete, "/dav/made", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE /dav/made: got %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	// the keys can't be read, replaced, or moved or deleted along with their directory
+	for _, tc := range []struct {
+		method, path, destination string
+		code                      int
+	}{
+		{http.MethodGet, "/dav/cert.key", "", http.StatusNotFound},
+		{http.MethodGet, "/dav/keys/sni.key", "", http.StatusNotFound},
+		{"PROPFIND", "/dav/cert.key", "", http.StatusNotFound},
+		{http.MethodPut, "/dav/cert.key", "", http.StatusNotFound},
+		{http.MethodPut, "/dav/keys/sni.key", "", http.StatusNotFound},
+		{"MOVE", "/dav/page.txt", "/dav/cert.key", http.StatusForbidden},
+		{"COPY", "/dav/page.txt", "/dav/keys/sni.key", http.StatusForbidden},
+		{"MOVE", "/dav/cert.key", "/dav/stolen.key", http.StatusForbidden},
+		{"COPY", "/dav/keys", "/dav/copied", http.StatusForbidden},
+		{"MOVE", "/dav/keys", "/dav/moved", http.StatusForbidden},
+		{http.MethodDelete, "/dav/keys", "", http.StatusForbidden},
+		{http.MethodDelete, "/dav/cert.key", "", http.StatusForbidden},
+		{http.MethodDelete, "/dav/", "", http.StatusForbidden},
+	} {
+		var header []string
+		if tc.destination != "" {
+			header = []string{"Destination", "http://example.com" + tc.destination}
+		}
+		if rec := do(tc.method, tc.path, "replaced", header...); rec.Code != tc.code {
+			t.Errorf("%s %s %s: got %d, want %d", tc.method, tc.path, tc.destination, rec.Code, tc.code)
+		}
+	}
+	if read("cert.key") != "key" || read("keys/sni.key") != "sni key" || read("page.txt") != "page" {
+		t.Errorf("keys changed to %q and %q, page to %q", read("cert.key"), read("keys/sni.key"), read("page.txt"))
+	}
+	for _, name := range []string{"stolen.key", "copied", "moved"} {
+		if _, err := os.Stat(filepath.Join(root, name)); err == nil {
+			t.Errorf("%s was created from a key", name)
+		}
+	}
+
+	// links can't lead out of the directory
+	if rec := do(http.MethodPut, "/dav/link/evil.txt", "evil"); rec.Code < 400 {
+		t.Errorf("PUT /dav/link/evil.txt: got %d, want it refused", rec.Code)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "evil.txt")); err == nil {
+		t.Error("PUT /dav/link/evil.txt wrote outside the directory")
+	}
+
+	webdavReadOnly = true
+	build()
+	for _, method := range []string{http.MethodPut, http.MethodDelete, "MKCOL", "MOVE", "COPY", "PROPPATCH", "LOCK"} {
+		if rec := do(method, "/dav/dir/b.txt", "x", "Destination", "http://example.com/dav/c.txt"); rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("-webdav-readonly, %s /dav/dir/b.txt: got %d, want %d", method, rec.Code, http.StatusMethodNotAllowed)
+		}
+	}
+	if rec := do("PROPFIND", "/dav/dir/", "", "Depth", "1"); rec.Code != http.StatusMultiStatus {
+		t.Errorf("-webdav-readonly, PROPFIND /dav/dir/: got %d, want %d", rec.Code, http.StatusMultiStatus)
+	}
+	if read("dir/b.txt") != "b" {
+		t.Errorf("-webdav-readonly changed dir/b.txt to %q", read("dir/b.txt"))
+	}
+
+	// WebDAV needs the same credentials as the files
+	authUser, authPass = "alice", "secret"
+	build()
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("PROPFIND", "/dav/", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("PROPFIND /dav/ without credentials: got %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := do("PROPFIND", "/dav/", "", "Depth", "0"); rec.Code != http.StatusMultiStatus {
+		t.Errorf("PROPFIND /dav/ with credentials: got %d, want %d", rec.Code, http.StatusMultiStatus)
+	}
+}